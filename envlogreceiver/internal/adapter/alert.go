@@ -0,0 +1,152 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/entry"
+)
+
+// networkAlertLogEntry is the entry of the alerts raised by the thresholds, with its own schema.
+type networkAlertLogEntry struct {
+	// Format is the schema version
+	Format string `json:"format"`
+	// Time is the time this entry was created in unix epoch milliseconds
+	Time     int64                       `json:"time"`
+	Events   []networkAlertLogEntryEvent `json:"events"`
+	Metadata map[string]string           `json:"metadata"`
+}
+
+// networkAlertLogEntryEvent reports that a measurement of the usage crossed a threshold.
+type networkAlertLogEntryEvent struct {
+	ID string `json:"id"`
+	// Timestamp is the time this entry was created in unix epoch milliseconds
+	Timestamp int64  `json:"timestamp"`
+	RootOrgID string `json:"root_org_id"`
+	OrgID     string `json:"org_id"`
+	EnvID     string `json:"env_id"`
+	AssetID   string `json:"asset_id"`
+	WorkerID  string `json:"worker_id"`
+	HostName  string `json:"host_name"`
+	// Threshold is the name of the crossed threshold
+	Threshold string `json:"threshold"`
+	// Type is the measurement compared with the threshold
+	Type string `json:"type"`
+	// Value is the current value of the measurement
+	Value float64 `json:"value"`
+	// ThresholdValue is the value of the crossed threshold
+	ThresholdValue float64 `json:"threshold_value"`
+	// WindowStartMs is the start of the window of the measurement in unix epoch milliseconds
+	WindowStartMs int64 `json:"window_start_ms"`
+	// WindowEndMs is the end of the window of the measurement in unix epoch milliseconds
+	WindowEndMs int64 `json:"window_end_ms"`
+	// UsageEventID is the ID of the usage event of the interval which crossed the threshold
+	UsageEventID string `json:"usage_event_id"`
+}
+
+// measurement is a value of the usage over a window, which is compared with the thresholds of its type.
+type measurement struct {
+	Value       float64
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// alert is a threshold crossed by a measurement.
+type alert struct {
+	threshold   logsampler.ThresholdConfig
+	measurement measurement
+}
+
+// checkThresholds compares the measurements of each type with the thresholds of that type. A
+// threshold raises an alert when the measurement is above it, and it is only re-armed once the
+// measurement is no longer above the threshold reduced by its hysteresis, so that a measurement
+// staying around the threshold raises a single alert. It returns the alerts to raise and the names
+// of the thresholds which are not re-armed yet.
+func checkThresholds(thresholds []logsampler.ThresholdConfig, raised map[string]bool, measurements map[string]measurement) ([]alert, map[string]bool) {
+	var alerts []alert
+	next := map[string]bool{}
+
+	for _, threshold := range thresholds {
+		m, found := measurements[threshold.Type]
+		if !found {
+			// Nothing measured, the threshold is left as it was
+			next[threshold.Name] = raised[threshold.Name]
+			continue
+		}
+
+		switch {
+		case raised[threshold.Name]:
+			next[threshold.Name] = m.Value > threshold.Value*(1-threshold.Hysteresis)
+		case m.Value > threshold.Value:
+			alerts = append(alerts, alert{threshold: threshold, measurement: m})
+			next[threshold.Name] = true
+		}
+	}
+
+	for name, isRaised := range next {
+		if !isRaised {
+			delete(next, name)
+		}
+	}
+
+	return alerts, next
+}
+
+// alertEntries checks the measurements of the interval against the thresholds, setting their new
+// state in the checkpoint, and returns the entry of the raised alerts, with a warning severity. It
+// does nothing when no thresholds are configured.
+func (b *usageEntryBuilder) alertEntries(ctx context.Context, checkpoint *samplerCheckpoint, measurements map[string]measurement, labels map[string]string, usageEventID string, ts int64) []samplerRecord {
+	if len(b.thresholds) == 0 {
+		return nil
+	}
+
+	raised := map[string]bool{}
+	if data, _ := b.persister.Get(ctx, logsampler.AlertsKey); data != nil {
+		// The thresholds are re-armed if the state is corrupted
+		_ = json.Unmarshal(data, &raised)
+	}
+
+	alerts, next := checkThresholds(b.thresholds, raised, measurements)
+	checkpoint.Alerts = next
+
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	var events []networkAlertLogEntryEvent
+	for _, a := range alerts {
+		events = append(events, networkAlertLogEntryEvent{
+			ID:             b.newID("alert", labels[labelWorkerID], b.metric, b.interfaceName, a.threshold.Name, strconv.FormatInt(a.measurement.WindowEnd.UnixMilli(), 10)),
+			Timestamp:      ts,
+			RootOrgID:      labels[labelRootOrgID],
+			OrgID:          labels[labelOrgID],
+			EnvID:          labels[labelEnvID],
+			AssetID:        labels[labelAssetID],
+			WorkerID:       labels[labelWorkerID],
+			HostName:       b.hostName,
+			Threshold:      a.threshold.Name,
+			Type:           a.threshold.Type,
+			Value:          a.measurement.Value,
+			ThresholdValue: a.threshold.Value,
+			WindowStartMs:  a.measurement.WindowStart.UnixMilli(),
+			WindowEndMs:    a.measurement.WindowEnd.UnixMilli(),
+			UsageEventID:   usageEventID,
+		})
+	}
+
+	schema := b.schema(logsampler.EntryAlert)
+	alertEntry := networkAlertLogEntry{
+		Format: schema.Version,
+		Time:   ts,
+		Events: events,
+		Metadata: map[string]string{
+			logsampler.SchemaID: schema.ID,
+		},
+	}
+
+	jsonEntry, _ := json.Marshal(alertEntry)
+	return []samplerRecord{{Body: jsonEntry, Severity: entry.Warn}}
+}
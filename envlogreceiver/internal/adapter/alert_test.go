@@ -0,0 +1,124 @@
+package adapter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckThresholds(t *testing.T) {
+	thresholds := []logsampler.ThresholdConfig{{Name: "egress", Type: logsampler.ThresholdDelta, Value: 100, Hysteresis: 0.2}}
+	delta := func(value float64) map[string]measurement {
+		return map[string]measurement{logsampler.ThresholdDelta: {Value: value}}
+	}
+
+	t.Run("Alert raised when the threshold is crossed", func(t *testing.T) {
+		alerts, raised := checkThresholds(thresholds, map[string]bool{}, delta(150))
+
+		assert.Len(t, alerts, 1)
+		assert.Equal(t, "egress", alerts[0].threshold.Name)
+		assert.Equal(t, 150.0, alerts[0].measurement.Value)
+		assert.True(t, raised["egress"])
+	})
+
+	t.Run("No alert below the threshold", func(t *testing.T) {
+		alerts, raised := checkThresholds(thresholds, map[string]bool{}, delta(100))
+
+		assert.Empty(t, alerts)
+		assert.Empty(t, raised)
+	})
+
+	t.Run("Repeated alerts suppressed by the hysteresis", func(t *testing.T) {
+		raised := map[string]bool{"egress": true}
+
+		alerts, raised := checkThresholds(thresholds, raised, delta(150))
+		assert.Empty(t, alerts)
+		alerts, raised = checkThresholds(thresholds, raised, delta(90))
+		assert.Empty(t, alerts)
+		alerts, raised = checkThresholds(thresholds, raised, delta(150))
+		assert.Empty(t, alerts, "The threshold should not be re-armed above the hysteresis")
+
+		_, raised = checkThresholds(thresholds, raised, delta(70))
+		assert.Empty(t, raised)
+		alerts, _ = checkThresholds(thresholds, raised, delta(150))
+		assert.Len(t, alerts, 1)
+	})
+
+	t.Run("Threshold without hysteresis re-armed when not above it", func(t *testing.T) {
+		strict := []logsampler.ThresholdConfig{{Name: "egress", Type: logsampler.ThresholdDelta, Value: 100}}
+
+		_, raised := checkThresholds(strict, map[string]bool{"egress": true}, delta(100))
+		assert.Empty(t, raised)
+	})
+
+	t.Run("Threshold without measurement left as it was", func(t *testing.T) {
+		daily := []logsampler.ThresholdConfig{{Name: "daily", Type: logsampler.ThresholdDailyTotal, Value: 100}}
+
+		alerts, raised := checkThresholds(daily, map[string]bool{"daily": true}, delta(0))
+
+		assert.Empty(t, alerts)
+		assert.True(t, raised["daily"])
+	})
+}
+
+func TestLogEntryAlerts(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	cfg := logsampler.LogSampler{Thresholds: []logsampler.ThresholdConfig{
+		{Name: "delta", Type: logsampler.ThresholdDelta, Value: 100},
+		{Name: "rate", Type: logsampler.ThresholdRate, Value: 1000},
+	}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{300, 500}}, cfg)
+	builder.intervalStart = time.UnixMilli(0)
+
+	written := emitLogEntries(t, builder, time.UnixMilli(20000))
+
+	assert.Len(t, written, 2)
+	var alertEntry struct {
+		Events []struct {
+			Threshold      string  `json:"threshold"`
+			Type           string  `json:"type"`
+			Value          float64 `json:"value"`
+			ThresholdValue float64 `json:"threshold_value"`
+			WindowStartMs  int64   `json:"window_start_ms"`
+			WindowEndMs    int64   `json:"window_end_ms"`
+			UsageEventID   string  `json:"usage_event_id"`
+		} `json:"events"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	assert.NoError(t, json.Unmarshal(written[1], &alertEntry))
+	assert.Equal(t, logsampler.NetworkAlertSchemaId, alertEntry.Metadata[logsampler.SchemaID])
+	assert.Len(t, alertEntry.Events, 1)
+	alert := alertEntry.Events[0]
+	assert.Equal(t, "delta", alert.Threshold)
+	assert.Equal(t, 200.0, alert.Value)
+	assert.Equal(t, 100.0, alert.ThresholdValue)
+	assert.Equal(t, int64(0), alert.WindowStartMs)
+	assert.Equal(t, int64(20000), alert.WindowEndMs)
+	assert.Equal(t, unmarshalLogEntry(t, written[0]).Events[0].ID, alert.UsageEventID)
+	assert.Equal(t, []byte(`{"delta":true}`), mockPersister.Data[logsampler.AlertsKey])
+
+	// The threshold stays crossed, so no other alert is raised
+	assert.Len(t, emitLogEntries(t, builder, time.UnixMilli(40000)), 1)
+}
+
+func TestLogEntryAlertsSuppressZero(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	cfg := logsampler.LogSampler{
+		SuppressZero:      true,
+		HeartbeatInterval: time.Hour,
+		Thresholds:        []logsampler.ThresholdConfig{{Name: "delta", Type: logsampler.ThresholdDelta, Value: 500}},
+	}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{1100, 1100, 2100}}, cfg)
+	builder.intervalStart = time.UnixMilli(0)
+
+	assert.Len(t, emitLogEntries(t, builder, time.UnixMilli(20000)), 2, "The spike should be alerted")
+	assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(40000)))
+	assert.Equal(t, []byte(`{}`), mockPersister.Data[logsampler.AlertsKey], "The idle interval should re-arm the threshold")
+
+	written := emitLogEntries(t, builder, time.UnixMilli(60000))
+	assert.Len(t, written, 2, "The spike after the idle interval should be alerted")
+	assert.Equal(t, logsampler.NetworkAlertSchemaId, unmarshalLogEntry(t, written[1]).Metadata[logsampler.SchemaID])
+}
@@ -0,0 +1,160 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+)
+
+// usageBatch holds the usage events waiting to be packed into an entry. It is persisted with
+// the checkpoint, so that the events survive restarts, and kept in memory when there is no storage.
+type usageBatch struct {
+	Events []networkIOLogEntryEvent `json:"events"`
+	// StartedAt is the time the first event was added in unix epoch milliseconds
+	StartedAt int64 `json:"started_at"`
+}
+
+// usageEntries returns the entries packing the usage events. When batching, the events are added to
+// the persisted batch, which is only written once it holds max_events_per_entry events, its first
+// event was added max_batch_delay ago, or flush is set because other entries refer to the events.
+// The new batch is set in the checkpoint.
+func (b *usageEntryBuilder) usageEntries(ctx context.Context, checkpoint *samplerCheckpoint, events []networkIOLogEntryEvent, now time.Time, flush bool) []samplerRecord {
+	if b.maxEventsPerEntry <= 1 {
+		return b.packEvents(ctx, events, 0, now)
+	}
+
+	batch := b.batch(ctx)
+	if len(batch.Events) == 0 {
+		if len(events) == 0 {
+			return nil
+		}
+		batch.StartedAt = now.UnixMilli()
+	}
+	batch.Events = append(batch.Events, events...)
+
+	delayed := b.maxBatchDelay > 0 && now.Sub(time.UnixMilli(batch.StartedAt)) >= b.maxBatchDelay
+	if len(batch.Events) < b.maxEventsPerEntry && !delayed && !flush {
+		checkpoint.Batch = &batch
+		return nil
+	}
+
+	checkpoint.Batch = &usageBatch{}
+	return b.packEvents(ctx, batch.Events, b.maxEventsPerEntry, now)
+}
+
+// flushBatch writes the usage events held in the batch, if any, with the given function and
+// removes the batch once they were written. It is used on shutdown, so that the events are not
+// held until the next start, or lost when there is no storage, and once the batch reaches its
+// deadline, so that the events are not held longer than max_batch_delay between two intervals.
+func (b *usageEntryBuilder) flushBatch(ctx context.Context, write func(samplerRecord) error) error {
+	if b.maxEventsPerEntry <= 1 {
+		return nil
+	}
+
+	batch := b.batch(ctx)
+	if len(batch.Events) == 0 {
+		return nil
+	}
+
+	for _, record := range b.packEvents(ctx, batch.Events, b.maxEventsPerEntry, b.now()) {
+		if err := write(record); err != nil {
+			return fmt.Errorf("write batch: %w", err)
+		}
+	}
+
+	return b.persister.Delete(ctx, logsampler.BatchKey)
+}
+
+// batchDeadline returns the time the first event of the batch was added plus max_batch_delay, or the
+// zero time when the batch is empty or there is no max_batch_delay.
+func (b *usageEntryBuilder) batchDeadline(ctx context.Context) time.Time {
+	if b.maxEventsPerEntry <= 1 || b.maxBatchDelay <= 0 {
+		return time.Time{}
+	}
+
+	batch := b.batch(ctx)
+	if len(batch.Events) == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(batch.StartedAt).Add(b.maxBatchDelay)
+}
+
+// batch returns the batch of usage events. A corrupted batch is discarded, and a new one started.
+func (b *usageEntryBuilder) batch(ctx context.Context) usageBatch {
+	var batch usageBatch
+	if data, _ := b.persister.Get(ctx, logsampler.BatchKey); data != nil {
+		_ = json.Unmarshal(data, &batch)
+	}
+	return batch
+}
+
+// usageEntry returns the entry of the usage events in the configured schema version.
+func (b *usageEntryBuilder) usageEntry(events []networkIOLogEntryEvent, now time.Time) any {
+	schema := b.schema(logsampler.EntryUsage)
+	metadata := map[string]string{
+		logsampler.SchemaID: schema.ID,
+	}
+
+	if schema.Version != logsampler.SchemaV2 {
+		return networkIOLogEntry{
+			Format:   schema.Version,
+			Time:     now.UnixMilli(),
+			Events:   events,
+			Metadata: metadata,
+		}
+	}
+
+	eventsV2 := make([]networkIOLogEntryEventV2, 0, len(events))
+	for _, evt := range events {
+		eventsV2 = append(eventsV2, networkIOLogEntryEventV2{
+			networkIOLogEntryEvent: evt,
+			Interface:              b.interfaceName,
+			Direction:              netstatsDirection,
+		})
+	}
+	return networkIOLogEntryV2{
+		Format:   schema.Version,
+		Time:     now.UnixMilli(),
+		Events:   eventsV2,
+		Metadata: metadata,
+	}
+}
+
+// renderUsageEntry renders the usage entry with the template, if any. The entry is serialized as it
+// is if the template fails, so that the usage is not lost, and the failure is logged and counted.
+func (b *usageEntryBuilder) renderUsageEntry(ctx context.Context, entry any) []byte {
+	if b.renderer != nil {
+		rendered, err := b.renderer.Render(entry)
+		if err == nil {
+			return rendered
+		}
+		b.logger.Warn("Could not render the sampler entry with the template, it is serialized as it is", zap.Error(err))
+		b.renderFailures.Add(ctx, 1)
+	}
+
+	jsonEntry, _ := json.Marshal(entry)
+	return jsonEntry
+}
+
+// packEvents packs the usage events into entries of at most maxEvents events, or into a single
+// entry when maxEvents is zero.
+func (b *usageEntryBuilder) packEvents(ctx context.Context, events []networkIOLogEntryEvent, maxEvents int, now time.Time) []samplerRecord {
+	var records []samplerRecord
+
+	for len(events) > 0 {
+		size := len(events)
+		if maxEvents > 0 && size > maxEvents {
+			size = maxEvents
+		}
+
+		records = append(records, samplerRecord{Body: b.renderUsageEntry(ctx, b.usageEntry(events[:size], now))})
+		events = events[size:]
+	}
+
+	return records
+}
@@ -0,0 +1,168 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+)
+
+func TestUsageEntriesBatching(t *testing.T) {
+	t.Run("Events packed once the batch is full", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 3}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110, 120, 130}}, cfg)
+		builder.intervalStart = time.UnixMilli(0)
+
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(20000)))
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(40000)))
+		assert.Contains(t, mockPersister.Data, logsampler.BatchKey)
+		assert.Equal(t, []byte("120"), mockPersister.Data[logsampler.LastCountKey], "The checkpoint is committed while the events are batched")
+
+		// The batch survives restarts
+		restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{130}}, cfg)
+		restarted.intervalStart = time.UnixMilli(40000)
+		written := emitLogEntries(t, restarted, time.UnixMilli(60000))
+
+		assert.Len(t, written, 1)
+		entry := unmarshalLogEntry(t, written[0])
+		assert.Len(t, entry.Events, 3)
+		for i, evt := range entry.Events {
+			assert.Equal(t, uint64(10), evt.UsageBytes)
+			assert.Equal(t, uint64(i+1), evt.Sequence)
+			assert.Equal(t, int64(i*20000), evt.IntervalStartMs)
+		}
+		assert.NotContains(t, mockPersister.Data, logsampler.BatchKey)
+	})
+
+	t.Run("Events packed once the batch is delayed", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 10, MaxBatchDelay: 30 * time.Second}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110, 120, 130}}, cfg)
+		clock := time.UnixMilli(20000)
+		builder.now = func() time.Time { return clock }
+
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		clock = clock.Add(20 * time.Second)
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		clock = clock.Add(20 * time.Second)
+		written := emitLogEntries(t, builder, clock)
+
+		assert.Len(t, written, 1)
+		assert.Len(t, unmarshalLogEntry(t, written[0]).Events, 3)
+	})
+
+	t.Run("Delayed batch written on a suppressed interval", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 10, MaxBatchDelay: 30 * time.Second, SuppressZero: true}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110}}, cfg)
+		clock := time.UnixMilli(20000)
+		builder.now = func() time.Time { return clock }
+
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		clock = clock.Add(20 * time.Second)
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		clock = clock.Add(20 * time.Second)
+		written := emitLogEntries(t, builder, clock)
+
+		assert.Len(t, written, 1)
+		assert.Len(t, unmarshalLogEntry(t, written[0]).Events, 1)
+		assert.NotContains(t, mockPersister.Data, logsampler.BatchKey)
+	})
+
+	t.Run("Batch written with the alerts referring to its events", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{
+			MaxEventsPerEntry: 10,
+			Thresholds:        []logsampler.ThresholdConfig{{Name: "egress", Type: logsampler.ThresholdDelta, Value: 50}},
+		}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110, 210}}, cfg)
+
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(20000)))
+		written := emitLogEntries(t, builder, time.UnixMilli(40000))
+
+		assert.Len(t, written, 2)
+		usage := unmarshalLogEntry(t, written[0])
+		assert.Len(t, usage.Events, 2, "The batch should be written with the alert")
+		var alertEntry networkAlertLogEntry
+		assert.NoError(t, json.Unmarshal(written[1], &alertEntry))
+		assert.Equal(t, usage.Events[1].ID, alertEntry.Events[0].UsageEventID)
+		assert.NotContains(t, mockPersister.Data, logsampler.BatchKey)
+	})
+
+	t.Run("Batch deadline", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 10, MaxBatchDelay: 30 * time.Second}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110, 120}}, cfg)
+		clock := time.UnixMilli(20000)
+		builder.now = func() time.Time { return clock }
+
+		assert.True(t, builder.batchDeadline(context.Background()).IsZero(), "There is no deadline without events")
+
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		clock = clock.Add(20 * time.Second)
+		assert.Empty(t, emitLogEntries(t, builder, clock))
+		assert.Equal(t, time.UnixMilli(50000), builder.batchDeadline(context.Background()), "The deadline should follow the first event")
+
+		assert.NoError(t, builder.flushBatch(context.Background(), func(samplerRecord) error { return nil }))
+		assert.True(t, builder.batchDeadline(context.Background()).IsZero())
+	})
+
+	t.Run("Events batched in memory without storage", func(t *testing.T) {
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 3}
+		builder := newTestUsageEntryBuilder(t, storage.NewNopClient(), &sequenceSampler{values: []uint64{110, 120, 130}}, cfg)
+
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(20000)))
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(40000)))
+		written := emitLogEntries(t, builder, time.UnixMilli(60000))
+
+		assert.Len(t, written, 1)
+		assert.Len(t, unmarshalLogEntry(t, written[0]).Events, 3)
+	})
+
+	t.Run("Batch flushed on shutdown", func(t *testing.T) {
+		cfg := logsampler.LogSampler{MaxEventsPerEntry: 3}
+		builder := newTestUsageEntryBuilder(t, storage.NewNopClient(), &sequenceSampler{values: []uint64{110, 120}}, cfg)
+
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(20000)))
+		assert.Empty(t, emitLogEntries(t, builder, time.UnixMilli(40000)))
+
+		var written [][]byte
+		write := func(record samplerRecord) error {
+			written = append(written, record.Body)
+			return nil
+		}
+		assert.NoError(t, builder.flushBatch(context.Background(), write))
+
+		assert.Len(t, written, 1)
+		assert.Len(t, unmarshalLogEntry(t, written[0]).Events, 2)
+
+		assert.NoError(t, builder.flushBatch(context.Background(), write))
+		assert.Len(t, written, 1, "The flushed batch should not be written again")
+	})
+
+	t.Run("Events written one entry per interval without batching", func(t *testing.T) {
+		builder := &usageEntryBuilder{}
+		events := []networkIOLogEntryEvent{{Sequence: 1}, {Sequence: 2}}
+
+		records := builder.usageEntries(context.Background(), &samplerCheckpoint{}, events, time.Now(), false)
+
+		assert.Len(t, records, 1)
+		assert.Len(t, unmarshalLogEntry(t, records[0].Body).Events, 2)
+	})
+
+	t.Run("Events split into entries of the maximum size", func(t *testing.T) {
+		builder := &usageEntryBuilder{}
+		events := []networkIOLogEntryEvent{{Sequence: 1}, {Sequence: 2}, {Sequence: 3}}
+
+		records := builder.packEvents(context.Background(), events, 2, time.Now())
+
+		assert.Len(t, records, 2)
+		assert.Len(t, unmarshalLogEntry(t, records[0].Body).Events, 2)
+		assert.Len(t, unmarshalLogEntry(t, records[1].Body).Events, 1)
+	})
+}
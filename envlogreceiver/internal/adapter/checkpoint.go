@@ -0,0 +1,189 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/rollup"
+)
+
+// samplerCheckpoint is the persisted state of a sampler once an interval has been emitted.
+type samplerCheckpoint struct {
+	// LastCount is the counter reading at the end of the interval
+	LastCount uint64 `json:"last_count"`
+	// CounterStart is the start time of the cumulative counter in unix epoch milliseconds, zero if unknown
+	CounterStart uint64 `json:"counter_start,omitempty"`
+	// Sequence is the sequence number of the last emitted event
+	Sequence uint64 `json:"sequence"`
+	// LastSampleTime is the time of the sample in unix epoch milliseconds
+	LastSampleTime uint64 `json:"last_sample_time,omitempty"`
+	// IntervalEnd is the end of the emitted interval in unix epoch milliseconds, which the next one starts at
+	IntervalEnd uint64 `json:"interval_end,omitempty"`
+	// IdleSince is the start of the span of suppressed zero usage intervals in unix epoch milliseconds,
+	// zero if the last interval was not suppressed
+	IdleSince uint64 `json:"idle_since,omitempty"`
+	// Rollup holds the running totals of the open rollup periods, nil when the rollups are disabled
+	Rollup *rollup.State `json:"rollup,omitempty"`
+	// Alerts holds the names of the thresholds which raised an alert and were not re-armed yet, nil
+	// when no thresholds are configured
+	Alerts map[string]bool `json:"alerts,omitempty"`
+	// Batch holds the usage events waiting to be packed into an entry, nil when it is unchanged
+	Batch *usageBatch `json:"batch,omitempty"`
+	// BootID is the boot ID of the system the count was read on, empty when unknown or neither audited nor cumulative
+	BootID string `json:"boot_id,omitempty"`
+}
+
+// pendingInterval is an interval whose entries were built but whose write was not confirmed yet.
+// It is persisted before the entries are written, and the checkpoint is only committed once the
+// writes succeeded. If the process dies or a write fails in between, the pending interval is
+// written again, with the same entries and therefore the same event IDs, before any new interval.
+type pendingInterval struct {
+	Entries    []samplerRecord   `json:"entries,omitempty"`
+	Checkpoint samplerCheckpoint `json:"checkpoint"`
+}
+
+// emit builds the entries of the interval ending at intervalEnd and writes them in order with the
+// given function, committing the new checkpoint only once the writes succeeded. A pending interval
+// left by a failed write or a previous run is written first.
+func (b *usageEntryBuilder) emit(ctx context.Context, intervalEnd time.Time, write func(samplerRecord) error) error {
+	if err := b.flushPending(ctx, write); err != nil {
+		return err
+	}
+
+	entries, checkpoint, err := b.logEntries(ctx, intervalEnd)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		// Nothing to write, the count is only recorded
+		return b.commit(ctx, checkpoint)
+	}
+
+	pending := pendingInterval{Entries: entries, Checkpoint: checkpoint}
+	if err := b.setPending(ctx, pending); err != nil {
+		return err
+	}
+
+	return b.writePending(ctx, pending, write)
+}
+
+// flushPending writes the pending interval, if any.
+func (b *usageEntryBuilder) flushPending(ctx context.Context, write func(samplerRecord) error) error {
+	data, _ := b.persister.Get(ctx, logsampler.PendingIntervalKey)
+	if data == nil {
+		return nil
+	}
+
+	var pending pendingInterval
+	if err := json.Unmarshal(data, &pending); err != nil {
+		// A corrupted record is discarded, otherwise it would block the sampler
+		return b.persister.Delete(ctx, logsampler.PendingIntervalKey)
+	}
+
+	return b.writePending(ctx, pending, write)
+}
+
+// writePending writes the entries of the pending interval, commits its checkpoint and removes it.
+// If a write fails, all the entries are written again the next time, so an entry may be written
+// more than once, with the same event ID.
+func (b *usageEntryBuilder) writePending(ctx context.Context, pending pendingInterval, write func(samplerRecord) error) error {
+	for _, record := range pending.Entries {
+		if err := write(record); err != nil {
+			return fmt.Errorf("write pending interval: %w", err)
+		}
+	}
+
+	if err := b.commit(ctx, pending.Checkpoint); err != nil {
+		return err
+	}
+
+	return b.persister.Delete(ctx, logsampler.PendingIntervalKey)
+}
+
+func (b *usageEntryBuilder) setPending(ctx context.Context, pending pendingInterval) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	if err := b.persister.Set(ctx, logsampler.PendingIntervalKey, data); err != nil {
+		return fmt.Errorf("persist pending interval: %w", err)
+	}
+	return nil
+}
+
+// commit persists the checkpoint. Each value is kept under its own key, so that LAST_COUNT stays
+// compatible with the previous versions.
+func (b *usageEntryBuilder) commit(ctx context.Context, checkpoint samplerCheckpoint) error {
+	values := map[string]uint64{
+		logsampler.LastCountKey: checkpoint.LastCount,
+		logsampler.SequenceKey:  checkpoint.Sequence,
+	}
+	if checkpoint.CounterStart != 0 {
+		values[logsampler.CounterStartKey] = checkpoint.CounterStart
+	}
+	if checkpoint.LastSampleTime != 0 {
+		values[logsampler.LastSampleTimeKey] = checkpoint.LastSampleTime
+	}
+	if checkpoint.IntervalEnd != 0 {
+		values[logsampler.IntervalEndKey] = checkpoint.IntervalEnd
+	}
+
+	for key, value := range values {
+		if err := b.persister.Set(ctx, key, []byte(strconv.FormatUint(value, 10))); err != nil {
+			return fmt.Errorf("persist %s: %w", key, err)
+		}
+	}
+
+	if checkpoint.BootID != "" {
+		if err := b.persister.Set(ctx, logsampler.BootIDKey, []byte(checkpoint.BootID)); err != nil {
+			return fmt.Errorf("persist %s: %w", logsampler.BootIDKey, err)
+		}
+	}
+
+	if checkpoint.Rollup != nil {
+		data, err := json.Marshal(checkpoint.Rollup)
+		if err != nil {
+			return err
+		}
+		if err := b.persister.Set(ctx, logsampler.RollupKey, data); err != nil {
+			return fmt.Errorf("persist %s: %w", logsampler.RollupKey, err)
+		}
+	}
+
+	if checkpoint.Alerts != nil {
+		data, err := json.Marshal(checkpoint.Alerts)
+		if err != nil {
+			return err
+		}
+		if err := b.persister.Set(ctx, logsampler.AlertsKey, data); err != nil {
+			return fmt.Errorf("persist %s: %w", logsampler.AlertsKey, err)
+		}
+	}
+
+	if checkpoint.Batch != nil {
+		if len(checkpoint.Batch.Events) == 0 {
+			if err := b.persister.Delete(ctx, logsampler.BatchKey); err != nil {
+				return fmt.Errorf("delete %s: %w", logsampler.BatchKey, err)
+			}
+		} else {
+			data, err := json.Marshal(checkpoint.Batch)
+			if err != nil {
+				return err
+			}
+			if err := b.persister.Set(ctx, logsampler.BatchKey, data); err != nil {
+				return fmt.Errorf("persist %s: %w", logsampler.BatchKey, err)
+			}
+		}
+	}
+
+	if checkpoint.IdleSince == 0 {
+		return b.persister.Delete(ctx, logsampler.IdleSinceKey)
+	}
+	return b.persister.Set(ctx, logsampler.IdleSinceKey, []byte(strconv.FormatUint(checkpoint.IdleSince, 10)))
+}
@@ -0,0 +1,102 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitCheckpoint(t *testing.T) {
+	t.Run("Checkpoint committed once the entry is written", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+
+		var written [][]byte
+		err := builder.emit(context.Background(), time.UnixMilli(20000), func(record samplerRecord) error {
+			// The interval is pending while it is being written
+			assert.Contains(t, mockPersister.Data, logsampler.PendingIntervalKey)
+			assert.Equal(t, []byte("50"), mockPersister.Data[logsampler.LastCountKey])
+			written = append(written, record.Body)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, written, 1)
+		assert.Equal(t, []byte("100"), mockPersister.Data[logsampler.LastCountKey])
+		assert.Equal(t, []byte("1"), mockPersister.Data[logsampler.SequenceKey])
+		assert.NotContains(t, mockPersister.Data, logsampler.PendingIntervalKey)
+	})
+
+	t.Run("Failed write keeps the interval pending and writes it again first", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 180}}, logsampler.LogSampler{})
+
+		var failed []byte
+		err := builder.emit(context.Background(), time.UnixMilli(20000), func(record samplerRecord) error {
+			failed = record.Body
+			return errors.New("pipeline unavailable")
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, []byte("50"), mockPersister.Data[logsampler.LastCountKey])
+		assert.Contains(t, mockPersister.Data, logsampler.PendingIntervalKey)
+
+		var written [][]byte
+		err = builder.emit(context.Background(), time.UnixMilli(40000), func(record samplerRecord) error {
+			written = append(written, record.Body)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, written, 2)
+		assert.Equal(t, failed, written[0])
+		pending := unmarshalLogEntry(t, written[0]).Events[0]
+		next := unmarshalLogEntry(t, written[1]).Events[0]
+		assert.Equal(t, uint64(50), pending.UsageBytes)
+		assert.Equal(t, uint64(80), next.UsageBytes)
+		assert.Equal(t, uint64(2), next.Sequence)
+		assert.Equal(t, []byte("180"), mockPersister.Data[logsampler.LastCountKey])
+	})
+
+	t.Run("Pending interval is written again with the same ID on restart", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+
+		var failed []byte
+		_ = builder.emit(context.Background(), time.UnixMilli(20000), func(record samplerRecord) error {
+			failed = record.Body
+			return errors.New("process died")
+		})
+
+		restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{130}}, logsampler.LogSampler{})
+
+		var written [][]byte
+		err := restarted.emit(context.Background(), time.UnixMilli(40000), func(record samplerRecord) error {
+			written = append(written, record.Body)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, written, 2)
+		assert.Equal(t, unmarshalLogEntry(t, failed).Events[0].ID, unmarshalLogEntry(t, written[0]).Events[0].ID)
+		assert.Equal(t, uint64(30), unmarshalLogEntry(t, written[1]).Events[0].UsageBytes)
+	})
+
+	t.Run("Skipped baseline only commits the checkpoint", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: make(map[string][]byte)}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{Baseline: logsampler.BaselineSkip})
+
+		err := builder.emit(context.Background(), time.UnixMilli(20000), func(record samplerRecord) error {
+			assert.Fail(t, "No entry should be written")
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("100"), mockPersister.Data[logsampler.LastCountKey])
+		assert.NotContains(t, mockPersister.Data, logsampler.PendingIntervalKey)
+	})
+}
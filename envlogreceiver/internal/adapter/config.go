@@ -4,6 +4,9 @@ package adapter
 
 import (
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/consumerretry"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/podinfo"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/resource"
 	"time"
 
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
@@ -12,9 +15,12 @@ import (
 
 // BaseConfig is the common configuration of a stanza-based receiver
 type BaseConfig struct {
-	Operators      []operator.Config    `mapstructure:"operators"`
-	StorageID      *component.ID        `mapstructure:"storage"`
-	RetryOnFailure consumerretry.Config `mapstructure:"retry_on_failure"`
+	Operators         []operator.Config    `mapstructure:"operators"`
+	StorageID         *component.ID        `mapstructure:"storage"`
+	RetryOnFailure    consumerretry.Config `mapstructure:"retry_on_failure"`
+	PodInfo           podinfo.Config       `mapstructure:"pod_info"`
+	ResourceDetection resource.Config      `mapstructure:"resource_detection"`
+	Identity          identity.Config      `mapstructure:"identity"`
 
 	// currently not configurable by users, but available for benchmarking
 	numWorkers    int
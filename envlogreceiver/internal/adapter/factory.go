@@ -6,8 +6,9 @@ package adapter
 import (
 	"context"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/consumerretry"
-	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/file"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/podinfo"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/resource"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator/helper"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/pipeline"
@@ -25,7 +26,6 @@ type LogReceiverType interface {
 	BaseConfig(component.Config) BaseConfig
 	InputConfig(component.Config) operator.Config
 	LogSamplers(component.Config) logsampler.Config
-	Input(cfg component.Config) file.Input
 }
 
 // NewFactory creates a factory for a Stanza-based receiver
@@ -47,7 +47,6 @@ func createLogsReceiver(logReceiverType LogReceiverType) rcvr.CreateLogsFunc {
 		inputCfg := logReceiverType.InputConfig(cfg)
 		baseCfg := logReceiverType.BaseConfig(cfg)
 		logSamplerCfg := logReceiverType.LogSamplers(cfg)
-		input := logReceiverType.Input(cfg)
 
 		operators := append([]operator.Config{inputCfg}, baseCfg.Operators...)
 
@@ -60,16 +59,10 @@ func createLogsReceiver(logReceiverType LogReceiverType) rcvr.CreateLogsFunc {
 			emitterOpts = append(emitterOpts, helper.WithFlushInterval(baseCfg.flushInterval))
 		}
 
-		sampler := ""
-		samplerUri := ""
-		samplerOutput := ""
-		samplerPollInterval := time.Minute
+		samplerConfig := logsampler.LogSampler{PollInterval: time.Minute}
 
 		if len(logSamplerCfg.LogSamplers) != 0 {
-			sampler = logSamplerCfg.LogSamplers[0].Metric
-			samplerPollInterval = logSamplerCfg.LogSamplers[0].PollInterval
-			samplerUri = logSamplerCfg.LogSamplers[0].URI
-			samplerOutput = logSamplerCfg.LogSamplers[0].Output
+			samplerConfig = logSamplerCfg.LogSamplers[0]
 		}
 
 		emitter := helper.NewLogEmitter(params.TelemetrySettings, emitterOpts...)
@@ -94,20 +87,29 @@ func createLogsReceiver(logReceiverType LogReceiverType) rcvr.CreateLogsFunc {
 			return nil, err
 		}
 
+		var podInfo *podinfo.Reader
+		if baseCfg.PodInfo.Enabled {
+			podInfo = podinfo.New(baseCfg.PodInfo)
+		}
+
+		var detectedResource map[string]string
+		if baseCfg.ResourceDetection.Enabled {
+			detectedResource = resource.Detect()
+		}
+
 		return &receiver{
-			set:                 params.TelemetrySettings,
-			id:                  params.ID,
-			pipe:                pipe,
-			emitter:             emitter,
-			consumer:            consumerretry.NewLogs(baseCfg.RetryOnFailure, params.Logger, nextConsumer),
-			converter:           converter,
-			obsrecv:             obsrecv,
-			storageID:           baseCfg.StorageID,
-			sampler:             sampler,
-			samplerPollInterval: samplerPollInterval,
-			samplerOutput:       samplerOutput,
-			samplerURI:          samplerUri,
-			input:               input,
+			set:              params.TelemetrySettings,
+			id:               params.ID,
+			pipe:             pipe,
+			emitter:          emitter,
+			consumer:         consumerretry.NewLogs(baseCfg.RetryOnFailure, params.Logger, nextConsumer),
+			converter:        converter,
+			obsrecv:          obsrecv,
+			storageID:        baseCfg.StorageID,
+			samplerConfig:    samplerConfig,
+			podInfo:          podInfo,
+			detectedResource: detectedResource,
+			identityEnabled:  baseCfg.Identity.Enabled,
 		}, nil
 	}
 }
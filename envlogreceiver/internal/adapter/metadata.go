@@ -0,0 +1,61 @@
+package adapter
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+)
+
+// metadataResolver resolves the identity fields of the sampler events.
+type metadataResolver interface {
+	Resolve() (map[string]string, error)
+}
+
+// sharedMetadata resolves the identity fields of the sampler events and of the resource of the
+// entries with the same resolver, so that both hold the same values. The sampler resolves the
+// fields on every interval, and the resource holds the values of the last resolution.
+type sharedMetadata struct {
+	resolver *identity.Resolver
+
+	mux    sync.Mutex
+	values map[string]string
+}
+
+var _ metadataResolver = (*sharedMetadata)(nil)
+
+func newSharedMetadata(cfg logsampler.LogSampler) (*sharedMetadata, error) {
+	resolver, err := identity.New(cfg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedMetadata{resolver: resolver}, nil
+}
+
+// Resolve resolves the identity fields. The values are kept for the resource unless the resolution
+// failed, in which case the values of the last successful one are kept, if any.
+func (m *sharedMetadata) Resolve() (map[string]string, error) {
+	values, err := m.resolver.Resolve()
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if err == nil || m.values == nil {
+		m.values = maps.Clone(values)
+	}
+	return values, err
+}
+
+// resource returns the identity fields of the last resolution added to the resource of the entries,
+// which have the same keys as in the sampler events.
+func (m *sharedMetadata) resource() map[string]string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	resource := map[string]string{}
+	for _, field := range identity.ResourceFields {
+		resource[field] = m.values[field]
+	}
+	return resource
+}
@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
+)
+
+// memoryPersister keeps the state of a sampler in memory, with the storage layered on top. Values
+// are read from the storage, falling back to memory when it has none, and written to both. Without
+// a storage extension, the state then holds while the receiver runs, but is lost on restart.
+type memoryPersister struct {
+	persister operator.Persister
+
+	mux  sync.Mutex
+	data map[string][]byte
+}
+
+var _ operator.Persister = (*memoryPersister)(nil)
+
+func newMemoryPersister(persister operator.Persister) *memoryPersister {
+	return &memoryPersister{persister: persister, data: map[string][]byte{}}
+}
+
+// Get returns the value of the key in the storage, or in memory if the storage has none.
+func (p *memoryPersister) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := p.persister.Get(ctx, key); err == nil && value != nil {
+		return value, nil
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	value, found := p.data[key]
+	if !found {
+		return nil, nil
+	}
+	return bytes.Clone(value), nil
+}
+
+// Set writes the value to the storage and, once written, to memory.
+func (p *memoryPersister) Set(ctx context.Context, key string, value []byte) error {
+	if err := p.persister.Set(ctx, key, value); err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.data[key] = bytes.Clone(value)
+	return nil
+}
+
+// Delete removes the key from the storage and, once removed, from memory.
+func (p *memoryPersister) Delete(ctx context.Context, key string) error {
+	if err := p.persister.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	delete(p.data, key)
+	return nil
+}
@@ -5,15 +5,22 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/file"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/outbox"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/podinfo"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/entry"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
+	"maps"
 	"sync"
 	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.opentelemetry.io/collector/pdata/plog"
 	rcvr "go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/receiver/receiverhelper"
 	"go.uber.org/multierr"
@@ -24,14 +31,11 @@ import (
 )
 
 type receiver struct {
-	set                 component.TelemetrySettings
-	samplerPollInterval time.Duration
-	sampler             string
-	samplerOutput       string
-	samplerURI          string
-	id                  component.ID
-	wg                  sync.WaitGroup
-	cancel              context.CancelFunc
+	set           component.TelemetrySettings
+	samplerConfig logsampler.LogSampler
+	id            component.ID
+	wg            sync.WaitGroup
+	cancel        context.CancelFunc
 
 	pipe      pipeline.Pipeline
 	emitter   *helper.LogEmitter
@@ -41,9 +45,30 @@ type receiver struct {
 
 	storageID     *component.ID
 	storageClient storage.Client
-	input         file.Input
+
+	// samplerCancel stops the sampler loop, which samplerWG waits for, so that the sampler entries
+	// are flushed while the pipeline still runs.
+	samplerCancel context.CancelFunc
+	samplerWG     sync.WaitGroup
+
+	// samplerOutbox holds the sampler entries until they are consumed. It is nil when disabled.
+	samplerOutbox *outbox.Outbox
+
+	// podInfo reads the attributes of the pod added to the resource of the entries. It is nil when disabled.
+	podInfo *podinfo.Reader
+	// detectedResource holds the detected attributes added to the resource of the entries. It is nil when disabled.
+	detectedResource map[string]string
+
+	// identityEnabled adds the identity fields resolved by metadata to the resource of the entries.
+	identityEnabled bool
+	// metadata resolves the identity fields of the sampler events and of the resource. It is nil when
+	// neither the sampler nor the identity is enabled.
+	metadata *sharedMetadata
 }
 
+// meterScope is the instrumentation scope of the receiver metrics
+const meterScope = "github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver"
+
 // Ensure this receiver adheres to required interface
 var _ rcvr.Logs = (*receiver)(nil)
 
@@ -57,6 +82,38 @@ func (r *receiver) Start(ctx context.Context, host component.Host) error {
 		return fmt.Errorf("storage client: %w", err)
 	}
 
+	if r.samplerConfig.Metric != "" || r.identityEnabled {
+		metadata, err := newSharedMetadata(r.samplerConfig)
+		if err != nil {
+			return fmt.Errorf("sampler metadata: %w", err)
+		}
+		r.metadata = metadata
+
+		// The required fields are checked on start, so that no events are emitted without them
+		if _, err := metadata.Resolve(); err != nil {
+			if r.samplerConfig.Metric != "" {
+				return fmt.Errorf("sampler metadata: %w", err)
+			}
+			r.set.Logger.Warn("Could not resolve all the identity fields", zap.Error(err))
+		}
+	}
+
+	if r.samplerConfig.Metric != "" && r.storageID == nil {
+		r.set.Logger.Warn("No storage extension is configured, so the sampler state is only kept in memory. " +
+			"The last count and the sequence number are lost on restart, so the first event after it is a baseline")
+	}
+
+	if r.samplerConfig.Outbox.Enabled {
+		if r.storageID == nil {
+			return errors.New("sampler outbox: a storage extension is required to hold the entries durably")
+		}
+		samplerOutbox, err := outbox.New(ctx, r.storageClient, r.samplerConfig.Outbox.MaxEntries, r.samplerConfig.Outbox.DeliveryTimeout, r.set.MeterProvider.Meter(meterScope))
+		if err != nil {
+			return fmt.Errorf("sampler outbox: %w", err)
+		}
+		r.samplerOutbox = samplerOutbox
+	}
+
 	if err := r.pipe.Start(r.storageClient); err != nil {
 		return fmt.Errorf("start stanza: %w", err)
 	}
@@ -83,8 +140,11 @@ func (r *receiver) Start(ctx context.Context, host component.Host) error {
 	// channel. In order to prevent backpressure, reading from the converter
 	// channel and batching are done in those 2 goroutines.
 
-	if r.sampler != "" {
-		go r.samplerLoop(rctx, r.storageClient)
+	if r.samplerConfig.Metric != "" {
+		samplerCtx, samplerCancel := context.WithCancel(rctx)
+		r.samplerCancel = samplerCancel
+		r.samplerWG.Add(1)
+		go r.samplerLoop(samplerCtx, r.storageClient)
 	}
 
 	return nil
@@ -108,6 +168,8 @@ func (r *receiver) emitterLoop(ctx context.Context) {
 				continue
 			}
 
+			addResource(e, r.resourceAttributes())
+
 			if err := r.converter.Batch(e); err != nil {
 				r.set.Logger.Error("Could not add entry to batch", zap.Error(err))
 			}
@@ -115,6 +177,53 @@ func (r *receiver) emitterLoop(ctx context.Context) {
 	}
 }
 
+// resourceAttributes returns the attributes added to the resource of the entries: the identity
+// fields and the environment attributes, nil if all are disabled.
+func (r *receiver) resourceAttributes() map[string]string {
+	attributes := r.environmentAttributes()
+	if !r.identityEnabled || r.metadata == nil {
+		return attributes
+	}
+
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	maps.Copy(attributes, r.metadata.resource())
+	return attributes
+}
+
+// environmentAttributes returns the attributes of the pod and the detected resource attributes, nil
+// if both are disabled. The pod attributes take precedence.
+func (r *receiver) environmentAttributes() map[string]string {
+	if r.podInfo == nil && r.detectedResource == nil {
+		return nil
+	}
+
+	attributes := maps.Clone(r.detectedResource)
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	if r.podInfo != nil {
+		maps.Copy(attributes, r.podInfo.Attributes())
+	}
+	return attributes
+}
+
+// addResource adds the attributes to the resource of the entries, keeping the resource attributes
+// which are already set.
+func addResource(entries []*entry.Entry, attributes map[string]string) {
+	for _, e := range entries {
+		for key, value := range attributes {
+			if e.Resource == nil {
+				e.Resource = map[string]any{}
+			}
+			if _, found := e.Resource[key]; !found {
+				e.Resource[key] = value
+			}
+		}
+	}
+}
+
 // consumerLoop reads converter log entries and calls the consumer to consumer them.
 func (r *receiver) consumerLoop(ctx context.Context) {
 	defer r.wg.Done()
@@ -135,15 +244,56 @@ func (r *receiver) consumerLoop(ctx context.Context) {
 			}
 			obsrecvCtx := r.obsrecv.StartLogsOp(ctx)
 			logRecordCount := pLogs.LogRecordCount()
+			outboxIDs := claimOutboxIDs(pLogs)
 			cErr := r.consumer.ConsumeLogs(ctx, pLogs)
 			if cErr != nil {
 				r.set.Logger.Error("ConsumeLogs() failed", zap.Error(cErr))
 			}
+			r.settleOutbox(ctx, outboxIDs, cErr)
 			r.obsrecv.EndLogsOp(obsrecvCtx, "stanza", logRecordCount, cErr)
 		}
 	}
 }
 
+// claimOutboxIDs removes the outbox attribute from the sampler records and returns their outbox IDs.
+func claimOutboxIDs(pLogs plog.Logs) []uint64 {
+	var ids []uint64
+
+	resourceLogs := pLogs.ResourceLogs()
+	for i := 0; i < resourceLogs.Len(); i++ {
+		scopeLogs := resourceLogs.At(i).ScopeLogs()
+		for j := 0; j < scopeLogs.Len(); j++ {
+			logRecords := scopeLogs.At(j).LogRecords()
+			for k := 0; k < logRecords.Len(); k++ {
+				attributes := logRecords.At(k).Attributes()
+				if id, ok := attributes.Get(samplerOutboxIDAttribute); ok {
+					ids = append(ids, uint64(id.Int()))
+					attributes.Remove(samplerOutboxIDAttribute)
+				}
+			}
+		}
+	}
+
+	return ids
+}
+
+// settleOutbox removes the sampler entries from the outbox once they were consumed, or marks
+// them to be delivered again otherwise.
+func (r *receiver) settleOutbox(ctx context.Context, ids []uint64, consumeErr error) {
+	if r.samplerOutbox == nil || len(ids) == 0 {
+		return
+	}
+
+	if consumeErr != nil {
+		r.samplerOutbox.Nack(ids)
+		return
+	}
+
+	if err := r.samplerOutbox.Ack(ctx, ids); err != nil {
+		r.set.Logger.Error("Could not remove the consumed entries from the sampler outbox", zap.Error(err))
+	}
+}
+
 // Shutdown is invoked during service shutdown
 func (r *receiver) Shutdown(ctx context.Context) error {
 	if r.cancel == nil {
@@ -151,6 +301,10 @@ func (r *receiver) Shutdown(ctx context.Context) error {
 	}
 
 	r.set.Logger.Info("Stopping stanza receiver")
+	if r.samplerCancel != nil {
+		r.samplerCancel()
+		r.samplerWG.Wait()
+	}
 	pipelineErr := r.pipe.Stop()
 	r.converter.Stop()
 	r.cancel()
@@ -163,23 +317,119 @@ func (r *receiver) Shutdown(ctx context.Context) error {
 	return pipelineErr
 }
 
+// samplerAttributes returns the function returning the attributes added to the sampler events, nil
+// if there are none. The identity fields are not added, since the events already hold them.
+func (r *receiver) samplerAttributes() func() map[string]string {
+	if r.podInfo == nil && r.detectedResource == nil {
+		return nil
+	}
+	return r.environmentAttributes
+}
+
 func (r *receiver) samplerLoop(ctx context.Context, persister operator.Persister) {
-	samplerEmitter, err := SamplerEmitterFactory(r.samplerOutput, r.samplerURI, persister, r.emitter, r.input)
+	defer r.samplerWG.Done()
+
+	samplerEmitter, err := SamplerEmitterFactory(r.set, r.samplerConfig, persister, r.emitter, r.fileInput(), r.samplerOutbox, r.samplerAttributes(), r.metadata)
 
 	if err != nil {
-		r.set.Logger.Debug("Error on sampler loop creation", zap.Error(err))
+		r.set.Logger.Error("Error on sampler loop creation", zap.Error(err))
 		return
 	}
 
-	ticker := time.NewTicker(r.samplerPollInterval)
-	defer ticker.Stop()
+	if err := samplerEmitter.Start(ctx); err != nil {
+		r.set.Logger.Error("Could not recover the sampler entries of the previous run", zap.Error(err))
+	}
+
+	emitInterval := r.samplerConfig.EffectiveEmitInterval()
+	start := time.Now()
+	if r.samplerConfig.AlignToInterval {
+		start = start.Truncate(emitInterval)
+	}
+	nextEmit := nextEmitTime(start, time.Now(), emitInterval, r.samplerConfig.AlignToInterval)
+	timer := time.NewTimer(time.Until(nextEmit))
+	defer timer.Stop()
+
+	// The batch is written once it is held for max_batch_delay, even between two emissions. The
+	// channel is nil, and never fires, when no events are held.
+	var batchTimer *time.Timer
+	var batchC <-chan time.Time
+	resetBatchTimer := func() {
+		if batchTimer != nil {
+			batchTimer.Stop()
+		}
+		batchC = nil
+		if deadline := samplerEmitter.BatchDeadline(ctx); !deadline.IsZero() {
+			batchTimer = time.NewTimer(time.Until(deadline))
+			batchC = batchTimer.C
+		}
+	}
+	resetBatchTimer()
+	defer func() {
+		if batchTimer != nil {
+			batchTimer.Stop()
+		}
+	}()
+
+	// Intermediate readings are only taken when a sample interval is configured,
+	// otherwise the channel is nil and never fires.
+	var sampleC <-chan time.Time
+	if r.samplerConfig.SampleInterval > 0 {
+		sampleTicker := time.NewTicker(r.samplerConfig.SampleInterval)
+		defer sampleTicker.Stop()
+		sampleC = sampleTicker.C
+	}
 
 	for {
 		select {
-		case <-ticker.C:
-			samplerEmitter.Emit(ctx)
+		case <-sampleC:
+			samplerEmitter.Observe(ctx)
+		case <-timer.C:
+			if err := samplerEmitter.Emit(ctx, nextEmit); err != nil {
+				r.set.Logger.Error("Could not emit the sampler entry, it will be emitted again on the next interval", zap.Error(err))
+			}
+			nextEmit = nextEmitTime(nextEmit, time.Now(), emitInterval, r.samplerConfig.AlignToInterval)
+			timer.Reset(time.Until(nextEmit))
+			resetBatchTimer()
+		case <-batchC:
+			if err := samplerEmitter.Flush(ctx); err != nil {
+				// The deadline passed, so the timer is only set again on the next emission
+				r.set.Logger.Error("Could not write the sampler batch, it will be written again on the next interval", zap.Error(err))
+				batchC = nil
+				continue
+			}
+			resetBatchTimer()
 		case <-ctx.Done():
+			// The receiver is stopping, the entries are flushed before the pipeline stops
+			if err := samplerEmitter.Flush(context.WithoutCancel(ctx)); err != nil {
+				r.set.Logger.Error("Could not flush the sampler entries on shutdown", zap.Error(err))
+			}
 			return
 		}
 	}
 }
+
+// fileInput returns the file input operator of the running pipeline, which the pipeline emitter
+// writes the sampler entries to.
+func (r *receiver) fileInput() *file.Input {
+	for _, op := range r.pipe.Operators() {
+		if input, ok := op.(*file.Input); ok {
+			return input
+		}
+	}
+	return nil
+}
+
+// nextEmitTime returns the time of the emission following the last one. When aligned, emissions
+// land on wall-clock boundaries of the interval (e.g. :00, :15, :30, :45 for 15s). Emissions which
+// were missed, because the process was suspended for instance, are skipped.
+func nextEmitTime(last time.Time, now time.Time, interval time.Duration, aligned bool) time.Time {
+	next := last.Add(interval)
+	if next.After(now) {
+		return next
+	}
+
+	if aligned {
+		return now.Truncate(interval).Add(interval)
+	}
+	return now.Add(interval)
+}
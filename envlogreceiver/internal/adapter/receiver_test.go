@@ -0,0 +1,191 @@
+package adapter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/entry"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/podinfo"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/resource"
+)
+
+func TestNextEmitTime(t *testing.T) {
+	interval := 15 * time.Second
+	boundary := time.Date(2024, 5, 1, 10, 0, 15, 0, time.UTC)
+
+	t.Run("Not aligned", func(t *testing.T) {
+		last := boundary.Add(3 * time.Second)
+
+		assert.Equal(t, last.Add(interval), nextEmitTime(last, last.Add(time.Millisecond), interval, false))
+	})
+
+	t.Run("Aligned", func(t *testing.T) {
+		now := boundary.Add(7 * time.Second)
+
+		first := nextEmitTime(now.Truncate(interval), now, interval, true)
+		second := nextEmitTime(first, first.Add(time.Millisecond), interval, true)
+
+		assert.Equal(t, boundary.Add(interval), first)
+		assert.Equal(t, boundary.Add(2*interval), second)
+	})
+
+	t.Run("Missed emissions are skipped", func(t *testing.T) {
+		now := boundary.Add(time.Minute + 2*time.Second)
+
+		assert.Equal(t, now.Add(interval), nextEmitTime(boundary, now, interval, false))
+		assert.Equal(t, boundary.Add(time.Minute+interval), nextEmitTime(boundary, now, interval, true))
+	})
+}
+
+func TestClaimOutboxIDs(t *testing.T) {
+	pLogs := plog.NewLogs()
+	logRecords := pLogs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+
+	tailed := logRecords.AppendEmpty()
+	tailed.Body().SetStr("tailed")
+	sampled := logRecords.AppendEmpty()
+	sampled.Body().SetStr("sampled")
+	sampled.Attributes().PutInt(samplerOutboxIDAttribute, 7)
+	sampled.Attributes().PutStr("key", "value")
+
+	ids := claimOutboxIDs(pLogs)
+
+	assert.Equal(t, []uint64{7}, ids)
+	_, found := sampled.Attributes().Get(samplerOutboxIDAttribute)
+	assert.False(t, found, "The outbox attribute should be removed before consuming")
+	assert.Equal(t, 1, sampled.Attributes().Len())
+}
+
+func TestSharedMetadata(t *testing.T) {
+	setRequiredIdentity(t)
+	cfg := logsampler.LogSampler{Metadata: map[string]identity.Source{
+		identity.OrgID: {Env: "ORG_ID", Required: true},
+	}}
+
+	t.Run("Required fields set", func(t *testing.T) {
+		t.Setenv("ORG_ID", "org")
+
+		metadata, err := newSharedMetadata(cfg)
+		assert.NoError(t, err)
+		values, err := metadata.Resolve()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "org", values[identity.OrgID])
+	})
+
+	t.Run("Required fields empty", func(t *testing.T) {
+		t.Setenv("ORG_ID", "")
+
+		metadata, err := newSharedMetadata(cfg)
+		assert.NoError(t, err)
+		_, err = metadata.Resolve()
+
+		assert.ErrorContains(t, err, identity.OrgID)
+	})
+
+	t.Run("Resource follows the resolutions of the sampler", func(t *testing.T) {
+		t.Setenv("ORG_ID", "org")
+
+		metadata, err := newSharedMetadata(cfg)
+		assert.NoError(t, err)
+		_, _ = metadata.Resolve()
+		assert.Equal(t, "org", metadata.resource()[identity.OrgID])
+
+		t.Setenv("ORG_ID", "moved")
+		_, _ = metadata.Resolve()
+		assert.Equal(t, "moved", metadata.resource()[identity.OrgID])
+
+		t.Setenv("ORG_ID", "")
+		_, err = metadata.Resolve()
+		assert.Error(t, err)
+		assert.Equal(t, "moved", metadata.resource()[identity.OrgID], "The last resolved values should be kept when the resolution fails")
+	})
+}
+
+func TestStartRequiredMetadata(t *testing.T) {
+	setRequiredIdentity(t)
+	t.Setenv(identity.EnvOrgID, "")
+
+	r := &receiver{
+		set:           componenttest.NewNopTelemetrySettings(),
+		samplerConfig: logsampler.LogSampler{Metric: "netstats", Output: logsampler.OutputPipelineEmitter, PollInterval: time.Minute},
+	}
+
+	err := r.Start(context.Background(), componenttest.NewNopHost())
+
+	assert.ErrorContains(t, err, identity.OrgID, "The default config should fail to start without ORG_ID")
+}
+
+func TestAddResource(t *testing.T) {
+	tailed := entry.New()
+	configured := entry.New()
+	configured.Resource = map[string]any{"k8s.namespace.name": "configured"}
+
+	addResource([]*entry.Entry{tailed, configured}, map[string]string{
+		"k8s.namespace.name": "apps",
+		"k8s.pod.label.tier": "gold",
+	})
+
+	assert.Equal(t, map[string]any{"k8s.namespace.name": "apps", "k8s.pod.label.tier": "gold"}, tailed.Resource)
+	assert.Equal(t, map[string]any{"k8s.namespace.name": "configured", "k8s.pod.label.tier": "gold"}, configured.Resource)
+}
+
+func TestResourceAttributes(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		r := &receiver{}
+
+		assert.Nil(t, r.resourceAttributes())
+		assert.Nil(t, r.samplerAttributes())
+	})
+
+	t.Run("Pod attributes take precedence", func(t *testing.T) {
+		directory := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(directory, "uid"), []byte("5c8a6f0e-3b2d-4f7a-9e1c-8d6b4a2f0c13"), 0600))
+
+		r := &receiver{
+			podInfo: podinfo.New(podinfo.Config{Enabled: true, Directory: directory}),
+			detectedResource: map[string]string{
+				resource.HostNameAttribute: "worker-0",
+				resource.PodUIDAttribute:   "detected",
+			},
+		}
+
+		expected := map[string]string{
+			resource.HostNameAttribute: "worker-0",
+			resource.PodUIDAttribute:   "5c8a6f0e-3b2d-4f7a-9e1c-8d6b4a2f0c13",
+		}
+		assert.Equal(t, expected, r.resourceAttributes())
+		assert.Equal(t, expected, r.samplerAttributes()())
+		assert.Equal(t, "detected", r.detectedResource[resource.PodUIDAttribute], "The detected attributes should not be modified")
+	})
+	t.Run("Identity fields only in the resource", func(t *testing.T) {
+		r := &receiver{
+			detectedResource: map[string]string{resource.HostNameAttribute: "worker-0"},
+			identityEnabled:  true,
+			metadata: &sharedMetadata{values: map[string]string{
+				identity.OrgID:    "org",
+				identity.WorkerID: "worker-1",
+				identity.Billable: "true",
+			}},
+		}
+
+		assert.Equal(t, map[string]string{
+			resource.HostNameAttribute: "worker-0",
+			identity.RootOrgID:         "",
+			identity.OrgID:             "org",
+			identity.EnvID:             "",
+			identity.AssetID:           "",
+			identity.WorkerID:          "worker-1",
+		}, r.resourceAttributes())
+		assert.Equal(t, map[string]string{resource.HostNameAttribute: "worker-0"}, r.samplerAttributes()())
+	})
+}
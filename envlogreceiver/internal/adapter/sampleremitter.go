@@ -4,15 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/anomaly"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/billing"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/encoder"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/file"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/host"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/lumberjack"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/outbox"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/render"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/rollup"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/stats/sampler"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/stats/scraper"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/stats/window"
 	"github.com/google/uuid"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/entry"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator/helper"
-	"log"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -28,6 +42,26 @@ type networkIOLogEntry struct {
 	Metadata map[string]string        `json:"metadata"`
 }
 
+// networkIOLogEntryV2 is the entry of the usage events in the v2 schema.
+type networkIOLogEntryV2 struct {
+	// Format is the schema version
+	Format string `json:"format"`
+	// Time is the time this entry was created in unix epoch milliseconds
+	Time     int64                      `json:"time"`
+	Events   []networkIOLogEntryEventV2 `json:"events"`
+	Metadata map[string]string          `json:"metadata"`
+}
+
+// networkIOLogEntryEventV2 is the usage event in the v2 schema, which adds the measured network
+// interface and direction to the v1 event.
+type networkIOLogEntryEventV2 struct {
+	networkIOLogEntryEvent
+	// Interface is the network interface the usage was measured on
+	Interface string `json:"interface"`
+	// Direction is the direction of the usage
+	Direction string `json:"direction"`
+}
+
 type networkIOLogEntryEvent struct {
 	ID string `json:"id"`
 	// Timestamp is the time this entry was created in unix epoch milliseconds
@@ -39,112 +73,1230 @@ type networkIOLogEntryEvent struct {
 	WorkerID   string `json:"worker_id"`
 	UsageBytes uint64 `json:"usage_bytes"`
 	Billable   bool   `json:"billable"`
+	// HostName is the name of the host the sample was taken on
+	HostName string `json:"host_name"`
+	// Sequence is a monotonically increasing number of the events of the worker, which survives restarts
+	Sequence uint64 `json:"sequence"`
+	// IntervalStartMs is the start of the interval covered by this event in unix epoch milliseconds
+	IntervalStartMs int64 `json:"interval_start_ms"`
+	// IntervalEndMs is the end of the interval covered by this event in unix epoch milliseconds
+	IntervalEndMs int64 `json:"interval_end_ms"`
+	// Mode is the value semantics of UsageBytes. It is omitted for delta so that the v1 shape is unchanged.
+	Mode string `json:"mode,omitempty"`
+	// StartTimestamp is the time the cumulative counter started in unix epoch milliseconds
+	StartTimestamp int64 `json:"start_timestamp,omitempty"`
+	// RatePerSecond is the usage per second since the previous sample. Only present in rate mode.
+	RatePerSecond *float64 `json:"rate_per_second,omitempty"`
+	// Baseline flags the first event after there was no previous count, e.g. after a fresh install or a storage wipe.
+	Baseline bool `json:"baseline,omitempty"`
+	// Heartbeat flags an event covering a span of zero usage intervals which were suppressed.
+	Heartbeat bool `json:"heartbeat,omitempty"`
+	// Partial flags an aligned event whose interval does not start on a boundary, such as the first one after a start.
+	Partial bool `json:"partial,omitempty"`
+	// Audit holds the raw counter readings the usage is computed from. Only present when audit is enabled.
+	Audit *networkIOAudit `json:"audit,omitempty"`
+	// Anomaly flags a suspicious event, which may be held for review before charging.
+	Anomaly *networkIOAnomaly `json:"anomaly,omitempty"`
+	// Window summarizes the intermediate readings taken since the previous event. Only present
+	// when a sample interval is configured.
+	Window *networkIOWindow `json:"window,omitempty"`
+	// BillingRule is the billing rule which decided Billable. Only present when billing rules are configured.
+	BillingRule string `json:"billing_rule,omitempty"`
+	// Attributes are the selected attributes of the pod and the detected resource attributes. Only
+	// present when the pod info or the resource detection is enabled.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// networkGapLogEntry is the entry of a gap event, with its own schema.
+type networkGapLogEntry struct {
+	// Format is the schema version
+	Format string `json:"format"`
+	// Time is the time this entry was created in unix epoch milliseconds
+	Time     int64                     `json:"time"`
+	Events   []networkGapLogEntryEvent `json:"events"`
+	Metadata map[string]string         `json:"metadata"`
+}
+
+// networkGapLogEntryEvent reports that no sample was taken for longer than the gap threshold,
+// e.g. because the receiver was down, so that it can be told apart from zero usage.
+type networkGapLogEntryEvent struct {
+	ID string `json:"id"`
+	// Timestamp is the time this entry was created in unix epoch milliseconds
+	Timestamp int64  `json:"timestamp"`
+	RootOrgID string `json:"root_org_id"`
+	OrgID     string `json:"org_id"`
+	EnvID     string `json:"env_id"`
+	AssetID   string `json:"asset_id"`
+	WorkerID  string `json:"worker_id"`
+	HostName  string `json:"host_name"`
+	// GapStartMs is the time of the last sample before the gap in unix epoch milliseconds
+	GapStartMs int64 `json:"gap_start_ms"`
+	// GapEndMs is the time of the first sample after the gap in unix epoch milliseconds
+	GapEndMs int64 `json:"gap_end_ms"`
+	// DurationMs is the duration of the gap in milliseconds
+	DurationMs int64 `json:"duration_ms"`
+	// UsageSpansGap tells whether the usage event emitted after the gap includes the usage during
+	// the gap. It does not when the counter was restarted during the gap, e.g. after a reboot.
+	UsageSpansGap bool `json:"usage_spans_gap"`
+	// UsageEventID is the ID of the usage event emitted after the gap
+	UsageEventID string `json:"usage_event_id"`
+}
+
+// networkRollupLogEntry is the entry of the summary events of closed rollup periods, with its own schema.
+type networkRollupLogEntry struct {
+	// Format is the schema version
+	Format string `json:"format"`
+	// Time is the time this entry was created in unix epoch milliseconds
+	Time     int64                        `json:"time"`
+	Events   []networkRollupLogEntryEvent `json:"events"`
+	Metadata map[string]string            `json:"metadata"`
+}
+
+// networkRollupLogEntryEvent summarizes the usage of a worker over a closed day or month.
+type networkRollupLogEntryEvent struct {
+	ID string `json:"id"`
+	// Timestamp is the time this entry was created in unix epoch milliseconds
+	Timestamp int64  `json:"timestamp"`
+	RootOrgID string `json:"root_org_id"`
+	OrgID     string `json:"org_id"`
+	EnvID     string `json:"env_id"`
+	AssetID   string `json:"asset_id"`
+	WorkerID  string `json:"worker_id"`
+	HostName  string `json:"host_name"`
+	// Period is the kind of period, day or month
+	Period string `json:"period"`
+	// PeriodStartMs is the start of the period in unix epoch milliseconds
+	PeriodStartMs int64 `json:"period_start_ms"`
+	// PeriodEndMs is the end of the period in unix epoch milliseconds
+	PeriodEndMs int64 `json:"period_end_ms"`
+	// UsageBytes is the total usage of the intervals ending in the period
+	UsageBytes uint64 `json:"usage_bytes"`
+	// Intervals is the number of intervals ending in the period
+	Intervals int `json:"intervals"`
+	// Gaps is the number of gaps detected in the period
+	Gaps int `json:"gaps"`
+	// GapDurationMs is the total duration of the gaps in milliseconds
+	GapDurationMs int64 `json:"gap_duration_ms"`
+}
+
+type networkIOAudit struct {
+	// PreviousCounter is the counter reading the usage is computed from
+	PreviousCounter uint64 `json:"previous_counter"`
+	// CurrentCounter is the counter reading at the end of the interval
+	CurrentCounter uint64 `json:"current_counter"`
+	// BootID identifies the boot of the system the counter was read on
+	BootID string `json:"boot_id,omitempty"`
+	// PreviousBootID identifies the boot of the system the previous counter was read on
+	PreviousBootID string `json:"previous_boot_id,omitempty"`
+	// ResetReason tells why the counter went backwards, in which case the usage is counted from zero
+	ResetReason string `json:"reset_reason,omitempty"`
+	// Source is the file the counter is read from
+	Source string `json:"source"`
+	// Interface is the network interface the counter belongs to
+	Interface string `json:"interface,omitempty"`
+}
+
+type networkIOAnomaly struct {
+	// Reasons are the reasons the event is suspicious
+	Reasons []string `json:"reasons"`
+	// MedianBytes is the rolling median of the recent usages, if known
+	MedianBytes uint64 `json:"median_bytes,omitempty"`
+	// SampleDurationMs is the time taken to read the counter in milliseconds
+	SampleDurationMs int64 `json:"sample_duration_ms"`
+}
+
+type networkIOWindow struct {
+	Samples           int     `json:"samples"`
+	MinBytes          uint64  `json:"min_bytes"`
+	MaxBytes          uint64  `json:"max_bytes"`
+	AvgBytes          float64 `json:"avg_bytes"`
+	P95Bytes          uint64  `json:"p95_bytes"`
+	PeakRatePerSecond float64 `json:"peak_rate_per_second"`
+}
+
+// samplerOutboxIDAttribute is the attribute identifying the outbox record of a sampler entry in the
+// pipeline. It is removed before the entries are consumed.
+const samplerOutboxIDAttribute = "sampler.outbox.id"
+
+// samplerRecord is a serialized entry written by a sampler emitter, along with the severity of the
+// record in the pipeline.
+type samplerRecord struct {
+	Body     []byte         `json:"body"`
+	Severity entry.Severity `json:"severity,omitempty"`
 }
 
 type SamplerEmitter interface {
-	Emit(context.Context)
+	// Start recovers the entries left by a previous run which were not confirmed as written.
+	Start(context.Context) error
+	// Observe takes an intermediate reading that is aggregated into the next emitted event.
+	Observe(context.Context)
+	// Emit emits the event for the interval since the previous one, which ends at intervalEnd.
+	// The sampler state is only committed once the event was written.
+	Emit(ctx context.Context, intervalEnd time.Time) error
+	// Flush writes the entries not written yet, including the usage events held in a batch. It is
+	// called when the receiver stops, and when the batch reaches its deadline.
+	Flush(context.Context) error
+	// BatchDeadline returns the time the usage events held in a batch must be written by, or the
+	// zero time when no events are held or there is no max_batch_delay.
+	BatchDeadline(context.Context) time.Time
 }
 
 type FileLoggerSamplerEmitter struct {
 	URI           string
-	metricsLogger log.Logger
-	persister     operator.Persister
-	sampler       sampler.Sampler
+	metricsLogger io.Writer
+	entryBuilder  *usageEntryBuilder
+	encoder       encoder.Encoder
 }
 
-func (e FileLoggerSamplerEmitter) Emit(ctx context.Context) {
-	jsonEntry := logEntry(ctx, e.persister, e.sampler)
-	e.metricsLogger.Println(string(jsonEntry))
+func (e FileLoggerSamplerEmitter) Start(ctx context.Context) error {
+	return e.entryBuilder.flushPending(ctx, e.write)
+}
+
+func (e FileLoggerSamplerEmitter) Observe(ctx context.Context) {
+	e.entryBuilder.observe()
+}
 
+func (e FileLoggerSamplerEmitter) Emit(ctx context.Context, intervalEnd time.Time) error {
+	return e.entryBuilder.emit(ctx, intervalEnd, e.write)
+}
+
+func (e FileLoggerSamplerEmitter) Flush(ctx context.Context) error {
+	if err := e.entryBuilder.flushPending(ctx, e.write); err != nil {
+		return err
+	}
+	return e.entryBuilder.flushBatch(ctx, e.write)
+}
+
+func (e FileLoggerSamplerEmitter) BatchDeadline(ctx context.Context) time.Time {
+	return e.entryBuilder.batchDeadline(ctx)
+}
+
+func (e FileLoggerSamplerEmitter) write(record samplerRecord) error {
+	messages, err := e.encoder.Encode(record.Body)
+	if err != nil {
+		return fmt.Errorf("encode entry: %w", err)
+	}
+
+	var framed []byte
+	for _, message := range messages {
+		framed = append(framed, e.encoder.Frame(message)...)
+	}
+	_, err = e.metricsLogger.Write(framed)
+	return err
 }
 
 type PipelineConsumerSamplerEmitter struct {
-	Emitter   helper.LogEmitter
-	persister operator.Persister
-	sampler   sampler.Sampler
-	input     file.Input
+	Emitter      *helper.LogEmitter
+	entryBuilder *usageEntryBuilder
+	input        *file.Input
+	// outbox holds the entries until the pipeline accepted them. It is nil when disabled.
+	outbox *outbox.Outbox
+	// encoder encodes the bodies of the entries. They are written as they are when nil.
+	encoder encoder.Encoder
+	// recordFormat is the format of the records, logsampler.RecordFormatString when empty.
+	recordFormat string
+}
+
+func (e PipelineConsumerSamplerEmitter) Start(ctx context.Context) error {
+	e.redeliver(ctx)
+	return e.entryBuilder.flushPending(ctx, e.writer(ctx))
 }
 
-func (e PipelineConsumerSamplerEmitter) Emit(ctx context.Context) {
-	jsonEntry := logEntry(ctx, e.persister, e.sampler)
-	e.input.Emit(ctx, jsonEntry, map[string]any{})
+func (e PipelineConsumerSamplerEmitter) Observe(ctx context.Context) {
+	e.entryBuilder.observe()
 }
 
-func SamplerEmitterFactory(output string, uri string, persister operator.Persister, emitter *helper.LogEmitter, input file.Input) (SamplerEmitter, error) {
-	fileBasedSampler := sampler.NewFileBasedSampler("/proc/net/dev", scraper.NewLinuxNetworkDevicesFileScraper())
+func (e PipelineConsumerSamplerEmitter) Emit(ctx context.Context, intervalEnd time.Time) error {
+	e.redeliver(ctx)
+	return e.entryBuilder.emit(ctx, intervalEnd, e.writer(ctx))
+}
+
+func (e PipelineConsumerSamplerEmitter) Flush(ctx context.Context) error {
+	if err := e.entryBuilder.flushPending(ctx, e.writer(ctx)); err != nil {
+		return err
+	}
+	return e.entryBuilder.flushBatch(ctx, e.writer(ctx))
+}
+
+func (e PipelineConsumerSamplerEmitter) BatchDeadline(ctx context.Context) time.Time {
+	return e.entryBuilder.batchDeadline(ctx)
+}
+
+// writer returns the function writing the records to the pipeline. Without an outbox, a record is
+// written once it is handed to the pipeline, whether the pipeline accepts it or not. With an outbox,
+// a record is written once it is persisted in the outbox, which delivers it again until the
+// pipeline accepts it.
+func (e PipelineConsumerSamplerEmitter) writer(ctx context.Context) func(samplerRecord) error {
+	return func(record samplerRecord) error {
+		if e.outbox == nil {
+			return e.emitRecord(ctx, record, map[string]any{})
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		outboxRecord, err := e.outbox.Append(ctx, data)
+		if err != nil {
+			return err
+		}
+
+		e.deliver(ctx, outboxRecord)
+		return nil
+	}
+}
+
+// redeliver delivers the outbox records which were not accepted by the pipeline, in order.
+func (e PipelineConsumerSamplerEmitter) redeliver(ctx context.Context) {
+	if e.outbox == nil {
+		return
+	}
+
+	for _, record := range e.outbox.Undelivered() {
+		e.deliver(ctx, record)
+	}
+}
 
-	switch output {
+func (e PipelineConsumerSamplerEmitter) deliver(ctx context.Context, outboxRecord outbox.Record) {
+	var record samplerRecord
+	if err := json.Unmarshal(outboxRecord.Entry, &record); err != nil || record.Body == nil {
+		// Records appended by previous versions hold the serialized entry only
+		record = samplerRecord{Body: outboxRecord.Entry}
+	}
+
+	if err := e.emitRecord(ctx, record, map[string]any{samplerOutboxIDAttribute: outboxRecord.ID}); err != nil {
+		e.outbox.Nack([]uint64{outboxRecord.ID})
+	}
+}
+
+// emitRecord writes the record to the pipeline as an entry per encoded message, with the record
+// severity and the given attributes. The body of the entries is a string, or bytes with a binary encoding.
+func (e PipelineConsumerSamplerEmitter) emitRecord(ctx context.Context, record samplerRecord, attrs map[string]any) error {
+	switch {
+	case e.recordFormat == logsampler.RecordFormatMap || e.recordFormat == logsampler.RecordFormatEvent:
+		return e.emitStructured(ctx, record, attrs)
+	case e.encoder == nil:
+		return e.emitBody(ctx, string(record.Body), record.Severity, attrs)
+	}
+
+	messages, err := e.encoder.Encode(record.Body)
+	if err != nil {
+		return fmt.Errorf("encode entry: %w", err)
+	}
+	for _, message := range messages {
+		var body any = string(message)
+		if e.encoder.Binary() {
+			body = message
+		}
+		if err := e.emitBody(ctx, body, record.Severity, attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitBody writes an entry with the given body, severity and attributes to the pipeline.
+func (e PipelineConsumerSamplerEmitter) emitBody(ctx context.Context, body any, severity entry.Severity, attrs map[string]any) error {
+	ent, err := e.newEntry(body, severity, attrs)
+	if err != nil {
+		return err
+	}
+
+	e.input.Write(ctx, ent)
+	return nil
+}
+
+// emitStructured writes the record to the pipeline as entries with a map body, one for the record
+// or one per event. The entries are stamped with the time of the events, their name and the identity
+// fields added to the configured resource, and are informational unless the record has a severity.
+func (e PipelineConsumerSamplerEmitter) emitStructured(ctx context.Context, record samplerRecord, attrs map[string]any) error {
+	records, err := structuredRecords(record.Body, e.recordFormat)
+	if err != nil {
+		return err
+	}
+
+	severity := record.Severity
+	if severity == entry.Default {
+		severity = entry.Info
+	}
+
+	for _, structured := range records {
+		ent, err := e.newEntry(structured.body, severity, attrs)
+		if err != nil {
+			return err
+		}
+
+		ent.Timestamp = structured.timestamp
+		if structured.eventName != "" {
+			if err := ent.Set(entry.NewAttributeField(eventNameAttribute), structured.eventName); err != nil {
+				return fmt.Errorf("set attribute: %w", err)
+			}
+		}
+		for k, v := range structured.resource {
+			ent.AddResourceKey(k, v)
+		}
+
+		e.input.Write(ctx, ent)
+	}
+	return nil
+}
+
+// newEntry creates an entry with the given body, severity and attributes.
+func (e PipelineConsumerSamplerEmitter) newEntry(body any, severity entry.Severity, attrs map[string]any) (*entry.Entry, error) {
+	ent, err := e.input.NewEntry(body)
+	if err != nil {
+		return nil, fmt.Errorf("create entry: %w", err)
+	}
+
+	ent.Severity = severity
+	for k, v := range attrs {
+		if err := ent.Set(entry.NewAttributeField(k), v); err != nil {
+			return nil, fmt.Errorf("set attribute: %w", err)
+		}
+	}
+	return ent, nil
+}
+
+// netDevFile is the file the network counters are read from
+const netDevFile = "/proc/net/dev"
+
+// netstatsDirection is the direction of the usage, which is the sum of the received and transmitted bytes
+const netstatsDirection = billing.DirectionTotal
+
+// SamplerEmitterFactory creates the emitter for the configured output, reporting with the given
+// telemetry settings. The pipeline emitter writes the entries to the given input, which must be the
+// file input of the running pipeline, holding them in the given outbox, if not nil, until the
+// pipeline accepted them. The attributes returned by the given function, if not nil, are added to
+// the usage events. The identity fields are resolved by the given metadata, if not nil, or from the
+// metadata of the config otherwise.
+func SamplerEmitterFactory(set component.TelemetrySettings, cfg logsampler.LogSampler, persister operator.Persister, emitter *helper.LogEmitter, input *file.Input, samplerOutbox *outbox.Outbox, attributes func() map[string]string, metadata *sharedMetadata) (SamplerEmitter, error) {
+	networkScraper := scraper.NewLinuxNetworkDevicesFileScraper()
+	fileBasedSampler := sampler.NewFileBasedSampler(netDevFile, networkScraper)
+	entryBuilder, err := newUsageEntryBuilder(persister, fileBasedSampler, cfg)
+	if err != nil {
+		return nil, err
+	}
+	entryBuilder.interfaceName = networkScraper.InterfaceName
+	entryBuilder.source = netDevFile
+	entryBuilder.attributes = attributes
+	entryBuilder.logger = set.Logger
+	entryBuilder.renderFailures, err = set.MeterProvider.Meter(meterScope).Int64Counter(
+		"sampler_template_failed_entries",
+		metric.WithDescription("Number of sampler entries serialized as they are because the template failed"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		entryBuilder.metadata = metadata
+	}
+
+	entryEncoder, err := encoder.New(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Output {
 	case logsampler.OutputFileLogger:
-		metricsLogger := log.New(&lumberjack.Logger{
-			Filename:   uri,
+		metricsLogger := &lumberjack.Logger{
+			Filename:   cfg.URI,
 			MaxSize:    100, // kilobytes
 			MaxBackups: 20,
-		}, "", 0)
+			Header:     entryEncoder.Header(),
+		}
 
 		return FileLoggerSamplerEmitter{
-			output,
-			*metricsLogger,
-			persister,
-			fileBasedSampler,
+			cfg.Output,
+			metricsLogger,
+			entryBuilder,
+			entryEncoder,
 		}, nil
 	case logsampler.OutputPipelineEmitter:
+		if input == nil {
+			return nil, fmt.Errorf("no file input found in the pipeline for output type: %s", cfg.Output)
+		}
+
 		return PipelineConsumerSamplerEmitter{
-			*emitter,
-			persister,
-			fileBasedSampler,
+			emitter,
+			entryBuilder,
 			input,
+			samplerOutbox,
+			entryEncoder,
+			cfg.RecordFormat,
 		}, nil
 	default:
-		return nil, fmt.Errorf("unknown output type: %s", output)
+		return nil, fmt.Errorf("unknown output type: %s", cfg.Output)
+	}
+}
+
+// schema returns the schema of the kind of entries in the configured version, which is the first
+// version if the configured one is unknown.
+func (b *usageEntryBuilder) schema(kind string) logsampler.Schema {
+	if schema, found := logsampler.LookupSchema(b.metric, kind, b.schemaVersion); found {
+		return schema
+	}
+	schema, _ := logsampler.LookupSchema(logsampler.MetricNetstats, kind, logsampler.SchemaV1)
+	return schema
+}
+
+// classify sets the billability of the event ending at intervalEnd with the billing rules, if any.
+func (b *usageEntryBuilder) classify(evt *networkIOLogEntryEvent, assetID string, intervalEnd time.Time) {
+	if b.billing == nil {
+		return
+	}
+
+	result := b.billing.Classify(billing.Event{
+		Interface: b.interfaceName,
+		Direction: netstatsDirection,
+		OrgID:     evt.OrgID,
+		EnvID:     evt.EnvID,
+		AssetID:   assetID,
+		Time:      intervalEnd,
+	}, evt.Billable)
+	evt.Billable = result.Billable
+	evt.BillingRule = result.Rule
+}
+
+// eventIDNamespace is the namespace of the deterministic event IDs
+var eventIDNamespace = uuid.MustParse("3c1f6a2e-8d4b-5e7a-9f60-2b7d4c8e1a93")
+
+// usageEntryBuilder builds the usage log entries of a sampler. Besides the persisted
+// state, it keeps in memory the readings and their monotonic time, which are only
+// meaningful while the process is running and are used to compute rates.
+type usageEntryBuilder struct {
+	persister operator.Persister
+	sampler   sampler.Sampler
+	metric    string
+	mode      string
+	idMode    string
+	baseline  string
+	now       func() time.Time
+	bootTime  func() (time.Time, error)
+	hostName  string
+
+	// gapThreshold is the elapsed time since the last sample above which a gap event is emitted.
+	// Gaps are not detected when it is zero, i.e. when the configured gap threshold is negative.
+	gapThreshold time.Duration
+
+	// alignInterval is the emit interval the interval ends are aligned to. It is zero when the
+	// emissions are not aligned.
+	alignInterval time.Duration
+
+	// suppressZero suppresses zero usage intervals, which are covered by heartbeat events spanning
+	// at most heartbeatInterval.
+	suppressZero      bool
+	heartbeatInterval time.Duration
+
+	// rollupLocation is the location of the day and month boundaries of the rollups. It is nil
+	// when the rollups are disabled.
+	rollupLocation *time.Location
+
+	// thresholds raise alerts when the usage crosses them
+	thresholds []logsampler.ThresholdConfig
+
+	// audit adds the raw counter readings to the events, with the boot IDs of the system they
+	// were read on and the source of the counter. The boot IDs are also read in cumulative mode, to
+	// detect the reboots which restarted the counter.
+	audit  bool
+	bootID func() (string, error)
+	source string
+
+	// maxEventsPerEntry is the maximum number of usage events packed into one entry, which is held
+	// for at most maxBatchDelay. The events are not batched when it is not greater than one.
+	maxEventsPerEntry int
+	maxBatchDelay     time.Duration
+
+	// anomalies flags suspicious events. It is nil when the anomaly flagging is disabled.
+	anomalies *anomaly.Detector
+
+	// metadata resolves the identity fields of the events, on every interval so that changes of
+	// the sources are picked up.
+	metadata metadataResolver
+
+	// schemaVersion is the version of the schema the entries are written with.
+	schemaVersion string
+
+	// renderer renders the usage entries. It is nil when they are serialized as they are.
+	renderer *render.Renderer
+	// renderFailures counts the usage entries serialized as they are because the template failed,
+	// which are logged with logger.
+	renderFailures metric.Int64Counter
+	logger         *zap.Logger
+
+	// billing decides the billability of the events. It is nil when no billing rules are configured.
+	billing *billing.Classifier
+
+	// attributes returns the attributes of the pod and resource added to the events. It is nil
+	// when none are.
+	attributes func() map[string]string
+
+	// interfaceName is the network interface sampled, which is part of the deterministic event IDs.
+	interfaceName string
+
+	// startReading is the reading taken when the builder was created, used as the baseline
+	// by the emit_since_process_start policy. It is nil if the reading failed.
+	startReading *uint64
+
+	// lastReading is the last reading, including the intermediate ones, which the window deltas
+	// are computed from.
+	lastReading   uint64
+	lastReadingAt time.Time
+
+	// intervalReading is the reading at the end of the previous interval, which the rate of the
+	// next interval is computed from.
+	intervalReading   uint64
+	intervalReadingAt time.Time
+
+	// intervalStart is the start of the interval covered by the next event.
+	intervalStart time.Time
+
+	// window aggregates the intermediate readings. It is nil when no sample interval is configured.
+	window *window.Aggregator
+}
+
+// newUsageEntryBuilder creates the builder of the entries of the sampler. It fails if the metadata,
+// the template or the billing rules of the config are invalid.
+func newUsageEntryBuilder(persister operator.Persister, sampler sampler.Sampler, cfg logsampler.LogSampler) (*usageEntryBuilder, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		mode = logsampler.ModeDelta
+	}
+
+	builder := &usageEntryBuilder{
+		persister:         newMemoryPersister(persister),
+		sampler:           sampler,
+		metric:            cfg.Metric,
+		mode:              mode,
+		idMode:            cfg.IDMode,
+		schemaVersion:     cfg.SchemaVersion,
+		baseline:          cfg.Baseline,
+		gapThreshold:      cfg.EffectiveGapThreshold(),
+		suppressZero:      cfg.SuppressZero,
+		heartbeatInterval: cfg.EffectiveHeartbeatInterval(),
+		thresholds:        cfg.Thresholds,
+		maxEventsPerEntry: cfg.MaxEventsPerEntry,
+		maxBatchDelay:     cfg.MaxBatchDelay,
+		now:               time.Now,
+		bootTime:          host.BootTime,
+		bootID:            host.BootID,
+		intervalStart:     time.Now(),
+		renderFailures:    noop.Int64Counter{},
+		logger:            zap.NewNop(),
+	}
+
+	if hostName, err := os.Hostname(); err == nil {
+		builder.hostName = hostName
+	}
+
+	metadata, err := identity.New(cfg.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("sampler metadata: %w", err)
+	}
+	builder.metadata = metadata
+
+	if cfg.Template != "" {
+		renderer, err := render.New(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("sampler template: %w", err)
+		}
+		builder.renderer = renderer
+	}
+
+	if len(cfg.BillingRules) > 0 {
+		classifier, err := billing.New(cfg.BillingRules)
+		if err != nil {
+			return nil, fmt.Errorf("sampler billing rules: %w", err)
+		}
+		builder.billing = classifier
+	}
+
+	if cfg.Baseline == logsampler.BaselineEmitSinceProcessStart {
+		if samp, err := sampler.Sample(); err == nil {
+			builder.startReading = &samp
+		}
+	}
+
+	if cfg.SampleInterval > 0 {
+		builder.window = &window.Aggregator{}
+	}
+
+	if cfg.AlignToInterval {
+		builder.alignInterval = cfg.EffectiveEmitInterval()
+	}
+
+	builder.audit = cfg.Audit
+
+	if cfg.Anomaly.Enabled {
+		builder.anomalies = anomaly.New(anomaly.Config{
+			SpikeFactor:       cfg.Anomaly.SpikeFactor,
+			MedianWindow:      cfg.Anomaly.MedianWindow,
+			MaxSampleDuration: cfg.Anomaly.MaxSampleDuration,
+		})
+	}
+
+	if cfg.Rollup.Enabled {
+		builder.rollupLocation = time.UTC
+		if location, err := time.LoadLocation(cfg.Rollup.Timezone); err == nil {
+			builder.rollupLocation = location
+		}
+	}
+
+	return builder, nil
+}
+
+// observe takes an intermediate reading and aggregates it into the current window.
+func (b *usageEntryBuilder) observe() {
+	samp, err := b.sampler.Sample()
+	if err != nil {
+		return
+	}
+
+	b.record(samp, b.now())
+}
+
+// record aggregates the delta since the last reading into the current window, if any, and
+// keeps the reading as the last one. Readings lower than the last one mean that the counter
+// was restarted, so they are not aggregated.
+func (b *usageEntryBuilder) record(samp uint64, now time.Time) {
+	if b.window != nil && !b.lastReadingAt.IsZero() && samp >= b.lastReading {
+		b.window.Add(samp-b.lastReading, now.Sub(b.lastReadingAt))
+	}
+
+	b.lastReading = samp
+	b.lastReadingAt = now
+}
+
+// recordInterval records the reading at the end of an interval, which is also the last reading.
+func (b *usageEntryBuilder) recordInterval(samp uint64, now time.Time) {
+	b.record(samp, now)
+
+	b.intervalReading = samp
+	b.intervalReadingAt = now
+}
+
+// Reasons of a counter reset
+const (
+	resetReboot  = "reboot"
+	resetCounter = "counter_reset"
+)
+
+// resetReason returns why the counter went backwards: a reboot when the boot ID changed, or
+// another reset, e.g. of the interface, otherwise. It is empty when the counter did not go backwards.
+func resetReason(counterBackwards bool, previousBootID, bootID string) string {
+	switch {
+	case !counterBackwards:
+		return ""
+	case previousBootID != "" && bootID != "" && previousBootID != bootID:
+		return resetReboot
+	default:
+		return resetCounter
 	}
 }
 
-func logEntry(ctx context.Context, persister operator.Persister, sampler sampler.Sampler) []byte {
-	byteSlice, _ := persister.Get(ctx, logsampler.LastCountKey)
+// windowSummary returns the summary of the current window and starts a new one. It returns nil
+// when no sample interval is configured.
+func (b *usageEntryBuilder) windowSummary() *networkIOWindow {
+	if b.window == nil {
+		return nil
+	}
+
+	summary := b.window.Summary()
+	b.window.Reset()
+
+	return &networkIOWindow{
+		Samples:           summary.Samples,
+		MinBytes:          summary.Min,
+		MaxBytes:          summary.Max,
+		AvgBytes:          summary.Avg,
+		P95Bytes:          summary.P95,
+		PeakRatePerSecond: summary.PeakRatePerSecond,
+	}
+}
+
+// logEntries builds the entries of the interval ending at intervalEnd, in the order they must be
+// written, along with the checkpoint to commit once the entries were written. There are no entries
+// when there is nothing to emit. When the sample fails, the error is returned and the interval is
+// left open, so that its usage is accounted in the next one.
+func (b *usageEntryBuilder) logEntries(ctx context.Context, intervalEnd time.Time) ([]samplerRecord, samplerCheckpoint, error) {
+	last_count, found := getUint(ctx, b.persister, logsampler.LastCountKey)
+	counterStart, _ := getUint(ctx, b.persister, logsampler.CounterStartKey)
+	sequence, _ := getUint(ctx, b.persister, logsampler.SequenceKey)
+	lastSampleTime, sampled := getUint(ctx, b.persister, logsampler.LastSampleTimeKey)
+	lastIntervalEnd, ended := getUint(ctx, b.persister, logsampler.IntervalEndKey)
+	idleSince, idling := getUint(ctx, b.persister, logsampler.IdleSinceKey)
+
+	// The events are not emitted without their identity fields, so the interval is left open, as
+	// when the sample fails, until the metadata resolves again
+	metadata, err := b.metadata.Resolve()
+	if err != nil {
+		return nil, samplerCheckpoint{}, fmt.Errorf("resolve sampler metadata: %w", err)
+	}
+
+	sampleStart := b.now()
+	samp, err := b.sampler.Sample()
+	if err != nil {
+		return nil, samplerCheckpoint{}, fmt.Errorf("sample %s: %w", b.metric, err)
+	}
+	now := b.now()
 
-	var last_count uint64 = 0
+	checkpoint := samplerCheckpoint{
+		LastCount:      samp,
+		CounterStart:   counterStart,
+		Sequence:       sequence,
+		LastSampleTime: uint64(now.UnixMilli()),
+		IntervalEnd:    uint64(intervalEnd.UnixMilli()),
+	}
 
-	if byteSlice != nil {
-		// Parse the string to an integer
-		counter, _ := strconv.ParseUint(string(byteSlice), 10, 64)
-		last_count = counter
+	// The boot ID the counter was read on is kept to tell a reboot from another counter reset
+	var previousBootID string
+	if b.audit || b.mode == logsampler.ModeCumulative {
+		if data, _ := b.persister.Get(ctx, logsampler.BootIDKey); data != nil {
+			previousBootID = string(data)
+		}
+		checkpoint.BootID, _ = b.bootID()
 	}
 
-	samp, _ := sampler.Sample()
+	intervalStart := b.intervalStart
+	b.intervalStart = intervalEnd
 
-	persister.Set(ctx, logsampler.LastCountKey, []byte(strconv.FormatUint(samp, 10)))
+	// The delta spans the time since the last sample, so when the receiver was restarted the interval
+	// resumes where the last emitted one ended, unless the counter was restarted in between. The time
+	// of the last sample is used when the end was not persisted by a previous version.
+	var lastSampleAt time.Time
+	spansLastSample := false
+	if found && sampled {
+		lastSampleAt = time.UnixMilli(int64(lastSampleTime))
+		spansLastSample = samp >= last_count
+		resumeAt := lastSampleAt
+		if ended {
+			resumeAt = time.UnixMilli(int64(lastIntervalEnd))
+		}
+		if spansLastSample && resumeAt.Before(intervalStart) {
+			intervalStart = resumeAt
+		}
+	}
+	gap := !lastSampleAt.IsZero() && b.gapThreshold > 0 && now.Sub(lastSampleAt) > b.gapThreshold
+	var gapDuration time.Duration
+	if gap {
+		gapDuration = now.Sub(lastSampleAt)
+	}
 
-	orgID := os.Getenv(logsampler.OrgID)
-	envID := os.Getenv(logsampler.EnvID)
-	deploymentID := os.Getenv(logsampler.DeploymentID)
-	rootOrgID := os.Getenv(logsampler.RootOrgID)
-	billingEnabled := os.Getenv(logsampler.MuleBillingEnabled) == "true"
-	workerID := "worker-" + strings.ReplaceAll(os.Getenv(logsampler.PodName), os.Getenv(logsampler.AppName)+"-", "")
-	ts := time.Now().Unix() * 1000
+	orgID := metadata[identity.OrgID]
+	envID := metadata[identity.EnvID]
+	deploymentID := metadata[identity.AssetID]
+	rootOrgID := metadata[identity.RootOrgID]
+	billingEnabled := metadata[identity.Billable] == "true"
+	workerID := metadata[identity.WorkerID]
+	var attributes map[string]string
+	if b.attributes != nil {
+		attributes = b.attributes()
+	}
+	ts := now.UnixMilli()
+
+	// Zero usage intervals are suppressed and coalesced into a heartbeat event covering the idle span.
+	// The heartbeat is emitted once the span reaches the heartbeat interval, after a gap, or before
+	// the event of the interval where the usage resumes.
+	heartbeat := false
+	var idleStart time.Time
+	if b.suppressZero && found {
+		idleStart = intervalStart
+		if idling {
+			idleStart = time.UnixMilli(int64(idleSince))
+		}
+
+		if samp == last_count {
+			if !gap && intervalEnd.Sub(idleStart) < b.heartbeatInterval {
+				checkpoint.IdleSince = uint64(idleStart.UnixMilli())
+				b.recordInterval(samp, now)
+				b.windowSummary()
+				labels := identityLabels(rootOrgID, orgID, envID, deploymentID, workerID)
+				entries := b.rollUp(ctx, &checkpoint, rollup.Interval{
+					End:    intervalEnd,
+					Labels: labels,
+				}, ts)
+				// A batch held for too long is written even if there is no new event
+				entries = append(entries, b.usageEntries(ctx, &checkpoint, nil, now, false)...)
+				// The idle interval re-arms the thresholds, so that a spike after it is alerted
+				return append(entries, b.alertEntries(ctx, &checkpoint, b.measurements(checkpoint, 0, intervalStart, intervalEnd), labels, "", ts)...), checkpoint, nil
+			}
+
+			heartbeat = true
+			intervalStart = idleStart
+		} else if !idling {
+			idleStart = time.Time{}
+		}
+	}
 
-	u, _ := uuid.NewRandom()
+	if !found {
+		switch b.baseline {
+		case logsampler.BaselineSkip:
+			// Only record the count, the usage is accounted from the next interval on
+			b.recordInterval(samp, now)
+			return nil, checkpoint, nil
+		case logsampler.BaselineEmitSinceProcessStart:
+			// The first interval already starts when the builder was created
+			last_count = samp
+			if b.startReading != nil && *b.startReading <= samp {
+				last_count = *b.startReading
+			}
+		default:
+			// The whole counter is emitted, so the interval starts when the counter started
+			if bootTime, err := b.bootTime(); err == nil {
+				intervalStart = bootTime
+			}
+		}
+	}
+
+	// A counter lower than the last count was restarted, so the usage is counted from zero
+	counterBackwards := found && samp < last_count
+	usage := samp
+	if !counterBackwards {
+		usage = samp - last_count
+	}
+
+	var events []networkIOLogEntryEvent
+
+	if !heartbeat && !idleStart.IsZero() {
+		// The usage resumed, so the idle span is closed first
+		checkpoint.Sequence++
+		idle := networkIOLogEntryEvent{
+			ID:              b.eventID(workerID, idleStart, intervalStart),
+			Timestamp:       ts,
+			RootOrgID:       rootOrgID,
+			OrgID:           orgID,
+			EnvID:           envID,
+			AssetID:         deploymentID,
+			WorkerID:        workerID,
+			Billable:        billingEnabled,
+			HostName:        b.hostName,
+			Sequence:        checkpoint.Sequence,
+			IntervalStartMs: idleStart.UnixMilli(),
+			IntervalEndMs:   intervalStart.UnixMilli(),
+			Heartbeat:       true,
+			Partial:         b.partial(idleStart),
+			Attributes:      attributes,
+		}
+		b.classify(&idle, deploymentID, intervalStart)
+
+		switch b.mode {
+		case logsampler.ModeCumulative:
+			idle.Mode = b.mode
+			idle.UsageBytes = last_count
+			idle.StartTimestamp = int64(counterStart)
+		case logsampler.ModeGauge:
+			idle.Mode = b.mode
+			idle.UsageBytes = last_count
+		case logsampler.ModeRate:
+			idle.Mode = b.mode
+			zero := 0.0
+			idle.RatePerSecond = &zero
+		}
+
+		events = append(events, idle)
+	}
+
+	checkpoint.Sequence++
 
 	evt := networkIOLogEntryEvent{
-		ID:         u.String(),
-		Timestamp:  ts,
-		RootOrgID:  rootOrgID,
-		OrgID:      orgID,
-		EnvID:      envID,
-		AssetID:    deploymentID,
-		WorkerID:   workerID,
-		UsageBytes: samp - last_count,
-		Billable:   billingEnabled,
-	}
-
-	logEntry := networkIOLogEntry{
-		Format: logsampler.Format,
+		ID:              b.eventID(workerID, intervalStart, intervalEnd),
+		Timestamp:       ts,
+		RootOrgID:       rootOrgID,
+		OrgID:           orgID,
+		EnvID:           envID,
+		AssetID:         deploymentID,
+		WorkerID:        workerID,
+		UsageBytes:      usage,
+		Billable:        billingEnabled,
+		HostName:        b.hostName,
+		Sequence:        checkpoint.Sequence,
+		IntervalStartMs: intervalStart.UnixMilli(),
+		IntervalEndMs:   intervalEnd.UnixMilli(),
+		Baseline:        !found,
+		Heartbeat:       heartbeat,
+		Partial:         b.partial(intervalStart),
+		Attributes:      attributes,
+	}
+	b.classify(&evt, deploymentID, intervalEnd)
+
+	switch b.mode {
+	case logsampler.ModeCumulative:
+		evt.Mode = b.mode
+		evt.UsageBytes = samp
+		rebooted := previousBootID != "" && checkpoint.BootID != "" && previousBootID != checkpoint.BootID
+		checkpoint.CounterStart = b.counterStartAt(counterStart, counterBackwards || rebooted, lastSampleAt, now)
+		evt.StartTimestamp = int64(checkpoint.CounterStart)
+	case logsampler.ModeGauge:
+		evt.Mode = b.mode
+		evt.UsageBytes = samp
+	case logsampler.ModeRate:
+		evt.Mode = b.mode
+		evt.RatePerSecond = b.rate(samp, now)
+	}
+
+	b.recordInterval(samp, now)
+	evt.Window = b.windowSummary()
+
+	if b.audit {
+		evt.Audit = &networkIOAudit{
+			PreviousCounter: last_count,
+			CurrentCounter:  samp,
+			BootID:          checkpoint.BootID,
+			PreviousBootID:  previousBootID,
+			ResetReason:     resetReason(counterBackwards, previousBootID, checkpoint.BootID),
+			Source:          b.source,
+			Interface:       b.interfaceName,
+		}
+	}
+
+	if b.anomalies != nil {
+		sampleDuration := now.Sub(sampleStart)
+		result := b.anomalies.Check(anomaly.Sample{
+			Usage:            usage,
+			CounterBackwards: counterBackwards,
+			Duration:         sampleDuration,
+		})
+		if len(result.Reasons) > 0 {
+			evt.Anomaly = &networkIOAnomaly{
+				Reasons:          result.Reasons,
+				MedianBytes:      result.Median,
+				SampleDurationMs: sampleDuration.Milliseconds(),
+			}
+		}
+	}
+
+	labels := identityLabels(rootOrgID, orgID, envID, deploymentID, workerID)
+	entries := b.rollUp(ctx, &checkpoint, rollup.Interval{
+		End:        intervalEnd,
+		UsageBytes: usage,
+		Gap:        gapDuration,
+		Labels:     labels,
+	}, ts)
+
+	if gap {
+		schema := b.schema(logsampler.EntryGap)
+		gapEntry := networkGapLogEntry{
+			Format: schema.Version,
+			Time:   ts,
+			Events: []networkGapLogEntryEvent{{
+				ID:            b.gapEventID(workerID, lastSampleAt, now),
+				Timestamp:     ts,
+				RootOrgID:     rootOrgID,
+				OrgID:         orgID,
+				EnvID:         envID,
+				AssetID:       deploymentID,
+				WorkerID:      workerID,
+				HostName:      b.hostName,
+				GapStartMs:    lastSampleAt.UnixMilli(),
+				GapEndMs:      ts,
+				DurationMs:    gapDuration.Milliseconds(),
+				UsageSpansGap: spansLastSample,
+				UsageEventID:  evt.ID,
+			}},
+			Metadata: map[string]string{
+				logsampler.SchemaID: schema.ID,
+			},
+		}
+
+		jsonGapEntry, _ := json.Marshal(gapEntry)
+		entries = append(entries, samplerRecord{Body: jsonGapEntry})
+	}
+
+	alerts := b.alertEntries(ctx, &checkpoint, b.measurements(checkpoint, usage, intervalStart, intervalEnd), labels, evt.ID, ts)
+	// The gap and alert entries refer to the usage event, so the batch holding it is written with them
+	entries = append(entries, b.usageEntries(ctx, &checkpoint, append(events, evt), now, gap || len(alerts) > 0)...)
+
+	return append(entries, alerts...), checkpoint, nil
+}
+
+// partial tells whether an aligned interval starting at intervalStart does not start on a boundary.
+func (b *usageEntryBuilder) partial(intervalStart time.Time) bool {
+	return b.alignInterval > 0 && !intervalStart.Truncate(b.alignInterval).Equal(intervalStart)
+}
+
+// rollUp rolls up the interval into the daily and monthly totals, setting the new totals in the
+// checkpoint, and returns the entry of the summary events of the periods closed by the interval.
+// It does nothing when the rollups are disabled.
+func (b *usageEntryBuilder) rollUp(ctx context.Context, checkpoint *samplerCheckpoint, interval rollup.Interval, ts int64) []samplerRecord {
+	if b.rollupLocation == nil {
+		return nil
+	}
+
+	var state rollup.State
+	if data, _ := b.persister.Get(ctx, logsampler.RollupKey); data != nil {
+		// The totals start over if the state is corrupted
+		_ = json.Unmarshal(data, &state)
+	}
+
+	next, closed := rollup.Add(state, interval, b.rollupLocation)
+	checkpoint.Rollup = &next
+
+	if len(closed) == 0 {
+		return nil
+	}
+
+	var events []networkRollupLogEntryEvent
+	for _, totals := range closed {
+		events = append(events, networkRollupLogEntryEvent{
+			ID:            b.newID("rollup", totals.Labels[labelWorkerID], b.metric, b.interfaceName, totals.Period, strconv.FormatInt(totals.StartMs, 10)),
+			Timestamp:     ts,
+			RootOrgID:     totals.Labels[labelRootOrgID],
+			OrgID:         totals.Labels[labelOrgID],
+			EnvID:         totals.Labels[labelEnvID],
+			AssetID:       totals.Labels[labelAssetID],
+			WorkerID:      totals.Labels[labelWorkerID],
+			HostName:      b.hostName,
+			Period:        totals.Period,
+			PeriodStartMs: totals.StartMs,
+			PeriodEndMs:   totals.EndMs,
+			UsageBytes:    totals.UsageBytes,
+			Intervals:     totals.Intervals,
+			Gaps:          totals.Gaps,
+			GapDurationMs: totals.GapDurationMs,
+		})
+	}
+
+	schema := b.schema(logsampler.EntryRollup)
+	summaryEntry := networkRollupLogEntry{
+		Format: schema.Version,
 		Time:   ts,
-		Events: []networkIOLogEntryEvent{evt},
+		Events: events,
 		Metadata: map[string]string{
-			logsampler.SchemaID: logsampler.NetworkSchemaId,
+			logsampler.SchemaID: schema.ID,
 		},
 	}
 
-	jsonEntry, _ := json.Marshal(logEntry)
-	return jsonEntry
+	jsonEntry, _ := json.Marshal(summaryEntry)
+	return []samplerRecord{{Body: jsonEntry}}
+}
+
+// measurements returns the measurements of the interval compared with the thresholds, by type.
+func (b *usageEntryBuilder) measurements(checkpoint samplerCheckpoint, usage uint64, intervalStart time.Time, intervalEnd time.Time) map[string]measurement {
+	measurements := map[string]measurement{
+		logsampler.ThresholdDelta: {Value: float64(usage), WindowStart: intervalStart, WindowEnd: intervalEnd},
+	}
+
+	if elapsed := intervalEnd.Sub(intervalStart).Seconds(); elapsed > 0 {
+		measurements[logsampler.ThresholdRate] = measurement{Value: float64(usage) / elapsed, WindowStart: intervalStart, WindowEnd: intervalEnd}
+	}
+
+	if checkpoint.Rollup != nil {
+		if day, found := checkpoint.Rollup.Current(rollup.PeriodDay); found {
+			measurements[logsampler.ThresholdDailyTotal] = measurement{Value: float64(day.UsageBytes), WindowStart: time.UnixMilli(day.StartMs), WindowEnd: intervalEnd}
+		}
+	}
+
+	return measurements
+}
+
+// Labels identifying whom the usage is accounted to in the rollups, named after the event fields
+const (
+	labelRootOrgID = "root_org_id"
+	labelOrgID     = "org_id"
+	labelEnvID     = "env_id"
+	labelAssetID   = "asset_id"
+	labelWorkerID  = "worker_id"
+)
+
+func identityLabels(rootOrgID string, orgID string, envID string, assetID string, workerID string) map[string]string {
+	return map[string]string{
+		labelRootOrgID: rootOrgID,
+		labelOrgID:     orgID,
+		labelEnvID:     envID,
+		labelAssetID:   assetID,
+		labelWorkerID:  workerID,
+	}
+}
+
+// counterStartAt returns the start time of the cumulative counter in unix epoch milliseconds. The
+// network counters count from boot, so the counter starts at the boot time. It only moves when the
+// counter was restarted, i.e. went backwards or was read on a new boot: to the boot time if the
+// system rebooted since the last sample, or to the last sample otherwise, after which the counter
+// was reset.
+func (b *usageEntryBuilder) counterStartAt(start uint64, restarted bool, lastSampleAt time.Time, now time.Time) uint64 {
+	if start != 0 && !restarted {
+		return start
+	}
+
+	startAt := now
+	if bootTime, err := b.bootTime(); err == nil {
+		startAt = bootTime
+	}
+	if restarted && lastSampleAt.After(startAt) {
+		startAt = lastSampleAt
+	}
+	return uint64(startAt.UnixMilli())
+}
+
+// eventID returns the ID of the event. Deterministic IDs are name based UUIDs (version 5) derived
+// from the worker, the sampler, the interface and the interval boundaries, so that a replayed or
+// retried sample gets the same ID and can be deduplicated downstream.
+func (b *usageEntryBuilder) eventID(workerID string, intervalStart time.Time, intervalEnd time.Time) string {
+	return b.newID(
+		workerID,
+		b.metric,
+		b.mode,
+		b.interfaceName,
+		strconv.FormatInt(intervalStart.UnixMilli(), 10),
+		strconv.FormatInt(intervalEnd.UnixMilli(), 10),
+	)
+}
+
+// gapEventID returns the ID of the gap event, derived like the event IDs from the worker, the
+// sampler, the interface and the gap boundaries.
+func (b *usageEntryBuilder) gapEventID(workerID string, gapStart time.Time, gapEnd time.Time) string {
+	return b.newID(
+		"gap",
+		workerID,
+		b.metric,
+		b.interfaceName,
+		strconv.FormatInt(gapStart.UnixMilli(), 10),
+		strconv.FormatInt(gapEnd.UnixMilli(), 10),
+	)
+}
+
+// newID returns a name based UUID (version 5) derived from the given parts, or a random UUID
+// in random id mode.
+func (b *usageEntryBuilder) newID(parts ...string) string {
+	if b.idMode == logsampler.IDModeRandom {
+		u, _ := uuid.NewRandom()
+		return u.String()
+	}
+
+	return uuid.NewSHA1(eventIDNamespace, []byte(strings.Join(parts, "|"))).String()
+}
+
+// rate returns the usage per second since the end of the previous interval. The elapsed time is
+// measured with the monotonic clock, so there is no rate for the first interval after the process
+// starts or after the counter was restarted.
+func (b *usageEntryBuilder) rate(samp uint64, now time.Time) *float64 {
+	if b.intervalReadingAt.IsZero() || samp < b.intervalReading {
+		return nil
+	}
+
+	elapsed := now.Sub(b.intervalReadingAt).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+
+	rate := float64(samp-b.intervalReading) / elapsed
+	return &rate
+}
+
+// getUint retrieves an unsigned integer stored as a string in the persister. It returns false
+// when the key was not found.
+func getUint(ctx context.Context, persister operator.Persister, key string) (uint64, bool) {
+	byteSlice, _ := persister.Get(ctx, key)
+
+	if byteSlice == nil {
+		return 0, false
+	}
+
+	// Parse the string to an integer
+	value, err := strconv.ParseUint(string(byteSlice), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
 }
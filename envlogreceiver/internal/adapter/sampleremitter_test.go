@@ -3,27 +3,127 @@ package adapter
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/anomaly"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/billing"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/encoder"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/file"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
 	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/outbox"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/podinfo"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/render"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/stats/sampler"
+	"github.com/google/uuid"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/entry"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator/helper"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Event represents the "events" array in the JSON.
 type Event struct {
-	ID         string `json:"id"`
-	Timestamp  int64  `json:"timestamp"`
-	RootOrgID  string `json:"root_org_id"`
-	OrgID      string `json:"org_id"`
-	EnvID      string `json:"env_id"`
-	AssetID    string `json:"asset_id"`
-	WorkerID   string `json:"worker_id"`
-	UsageBytes uint64 `json:"usage_bytes"`
-	Billable   bool   `json:"billable"`
+	ID              string            `json:"id"`
+	Timestamp       int64             `json:"timestamp"`
+	RootOrgID       string            `json:"root_org_id"`
+	OrgID           string            `json:"org_id"`
+	EnvID           string            `json:"env_id"`
+	AssetID         string            `json:"asset_id"`
+	WorkerID        string            `json:"worker_id"`
+	UsageBytes      uint64            `json:"usage_bytes"`
+	Billable        bool              `json:"billable"`
+	HostName        string            `json:"host_name"`
+	Sequence        uint64            `json:"sequence"`
+	IntervalStartMs int64             `json:"interval_start_ms"`
+	IntervalEndMs   int64             `json:"interval_end_ms"`
+	Mode            string            `json:"mode"`
+	StartTimestamp  int64             `json:"start_timestamp"`
+	RatePerSecond   *float64          `json:"rate_per_second"`
+	Baseline        bool              `json:"baseline"`
+	Heartbeat       bool              `json:"heartbeat"`
+	Partial         bool              `json:"partial"`
+	Audit           *Audit            `json:"audit"`
+	Anomaly         *Anomaly          `json:"anomaly"`
+	Window          *Window           `json:"window"`
+	BillingRule     string            `json:"billing_rule"`
+	Attributes      map[string]string `json:"attributes"`
+}
+
+// Audit represents the "audit" of an event in the JSON.
+type Audit struct {
+	PreviousCounter uint64 `json:"previous_counter"`
+	CurrentCounter  uint64 `json:"current_counter"`
+	BootID          string `json:"boot_id"`
+	PreviousBootID  string `json:"previous_boot_id"`
+	ResetReason     string `json:"reset_reason"`
+	Source          string `json:"source"`
+	Interface       string `json:"interface"`
+}
+
+// Anomaly represents the "anomaly" of an event in the JSON.
+type Anomaly struct {
+	Reasons          []string `json:"reasons"`
+	MedianBytes      uint64   `json:"median_bytes"`
+	SampleDurationMs int64    `json:"sample_duration_ms"`
+}
+
+// Window represents the "window" summary of an event in the JSON.
+type Window struct {
+	Samples           int     `json:"samples"`
+	MinBytes          uint64  `json:"min_bytes"`
+	MaxBytes          uint64  `json:"max_bytes"`
+	AvgBytes          float64 `json:"avg_bytes"`
+	P95Bytes          uint64  `json:"p95_bytes"`
+	PeakRatePerSecond float64 `json:"peak_rate_per_second"`
+}
+
+// GapEvent represents the "events" array of a gap entry in the JSON.
+type GapEvent struct {
+	ID            string `json:"id"`
+	WorkerID      string `json:"worker_id"`
+	GapStartMs    int64  `json:"gap_start_ms"`
+	GapEndMs      int64  `json:"gap_end_ms"`
+	DurationMs    int64  `json:"duration_ms"`
+	UsageSpansGap bool   `json:"usage_spans_gap"`
+	UsageEventID  string `json:"usage_event_id"`
+}
+
+// GapLogEntry represents the JSON structure of a gap entry.
+type GapLogEntry struct {
+	Format   string            `json:"format"`
+	Events   []GapEvent        `json:"events"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// RollupEvent represents the "events" array of a rollup summary entry in the JSON.
+type RollupEvent struct {
+	ID            string `json:"id"`
+	WorkerID      string `json:"worker_id"`
+	Period        string `json:"period"`
+	PeriodStartMs int64  `json:"period_start_ms"`
+	PeriodEndMs   int64  `json:"period_end_ms"`
+	UsageBytes    uint64 `json:"usage_bytes"`
+	Intervals     int    `json:"intervals"`
+	Gaps          int    `json:"gaps"`
+}
+
+// RollupLogEntry represents the JSON structure of a rollup summary entry.
+type RollupLogEntry struct {
+	Events   []RollupEvent     `json:"events"`
+	Metadata map[string]string `json:"metadata"`
 }
 
 // LogEntry represents the entire JSON structure.
@@ -79,7 +179,7 @@ func TestSamplerEmitterFactory(t *testing.T) {
 		mockInput := &file.Input{}
 
 		// Call SamplerEmitterFactory
-		samplerEmitter, err := SamplerEmitterFactory(logsampler.OutputFileLogger, "test.log", mockPersister, mockEmitter, *mockInput)
+		samplerEmitter, err := SamplerEmitterFactory(componenttest.NewNopTelemetrySettings(), logsampler.LogSampler{Output: logsampler.OutputFileLogger, URI: "test.log"}, mockPersister, mockEmitter, mockInput, nil, nil, nil)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -100,7 +200,7 @@ func TestSamplerEmitterFactory(t *testing.T) {
 		mockInput := &file.Input{}
 
 		// Call SamplerEmitterFactory
-		samplerEmitter, err := SamplerEmitterFactory(logsampler.OutputPipelineEmitter, "test.log", mockPersister, mockEmitter, *mockInput)
+		samplerEmitter, err := SamplerEmitterFactory(componenttest.NewNopTelemetrySettings(), logsampler.LogSampler{Output: logsampler.OutputPipelineEmitter, URI: "test.log"}, mockPersister, mockEmitter, mockInput, nil, nil, nil)
 
 		// Assertions
 		assert.NoError(t, err)
@@ -108,6 +208,19 @@ func TestSamplerEmitterFactory(t *testing.T) {
 		assert.IsType(t, PipelineConsumerSamplerEmitter{}, samplerEmitter)
 	})
 
+	t.Run("OutputPipelineEmitterWithoutInput", func(t *testing.T) {
+		mockPersister := &MockPersister{
+			Data: make(map[string][]byte),
+		}
+
+		// Call SamplerEmitterFactory
+		samplerEmitter, err := SamplerEmitterFactory(componenttest.NewNopTelemetrySettings(), logsampler.LogSampler{Output: logsampler.OutputPipelineEmitter}, mockPersister, &helper.LogEmitter{}, nil, nil, nil, nil)
+
+		// Assertions
+		assert.Error(t, err)
+		assert.Nil(t, samplerEmitter)
+	})
+
 	t.Run("UnknownOutputType", func(t *testing.T) {
 		// Prepare mock data
 		mockPersister := &MockPersister{
@@ -122,7 +235,7 @@ func TestSamplerEmitterFactory(t *testing.T) {
 		mockInput := &file.Input{}
 
 		// Call SamplerEmitterFactory
-		samplerEmitter, err := SamplerEmitterFactory("unknown_output", "test.log", mockPersister, mockEmitter, *mockInput)
+		samplerEmitter, err := SamplerEmitterFactory(componenttest.NewNopTelemetrySettings(), logsampler.LogSampler{Output: "unknown_output", URI: "test.log"}, mockPersister, mockEmitter, mockInput, nil, nil, nil)
 
 		// Assertions
 		assert.Error(t, err)
@@ -142,7 +255,7 @@ func TestLogEntry(t *testing.T) {
 	mockSampler := &mockSampler{}
 
 	// Call logEntry function
-	jsonEntry := logEntry(context.Background(), mockPersister, mockSampler)
+	jsonEntry := emitLogEntry(t, newTestUsageEntryBuilder(t, mockPersister, mockSampler, logsampler.LogSampler{}), time.Now())
 
 	var logEntry LogEntry
 	json.Unmarshal([]byte(jsonEntry), &logEntry)
@@ -152,6 +265,1098 @@ func TestLogEntry(t *testing.T) {
 	assert.Equal(t, logsampler.NetworkSchemaId, logEntry.Metadata[logsampler.SchemaID])
 }
 
+func TestLogEntryCounterBackwards(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{50}}, logsampler.LogSampler{})
+
+	evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+	assert.Equal(t, uint64(50), evt.UsageBytes, "The usage should be counted from zero after a counter restart")
+}
+
+func TestLogEntrySampleError(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{
+		logsampler.LastCountKey:      []byte("100"),
+		logsampler.SequenceKey:       []byte("4"),
+		logsampler.LastSampleTimeKey: []byte("10000"),
+	}}
+	sampler := &failingSampler{failures: 1, values: []uint64{250}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, sampler, logsampler.LogSampler{})
+	builder.intervalStart = time.UnixMilli(10000)
+	builder.now = func() time.Time { return time.UnixMilli(30000) }
+
+	var written [][]byte
+	err := builder.emit(context.Background(), time.UnixMilli(30000), func(record samplerRecord) error {
+		written = append(written, record.Body)
+		return nil
+	})
+
+	assert.Error(t, err)
+	assert.Empty(t, written, "No event should be emitted when the sample fails")
+	assert.Equal(t, []byte("100"), mockPersister.Data[logsampler.LastCountKey])
+	assert.Equal(t, []byte("4"), mockPersister.Data[logsampler.SequenceKey])
+	assert.Equal(t, []byte("10000"), mockPersister.Data[logsampler.LastSampleTimeKey])
+
+	builder.now = func() time.Time { return time.UnixMilli(50000) }
+	evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(50000))).Events[0]
+
+	assert.Equal(t, uint64(150), evt.UsageBytes)
+	assert.Equal(t, uint64(5), evt.Sequence)
+	assert.Equal(t, int64(10000), evt.IntervalStartMs, "The usage of the failed interval should be accounted in the next one")
+}
+
+// failingSampler is a mock implementation of sampler.Sampler which fails the given number of times
+// before returning the given values in order
+type failingSampler struct {
+	failures int
+	values   []uint64
+}
+
+func (s *failingSampler) Sample() (uint64, error) {
+	if s.failures > 0 {
+		s.failures--
+		return 0, errors.New("unreadable counter")
+	}
+	value := s.values[0]
+	if len(s.values) > 1 {
+		s.values = s.values[1:]
+	}
+	return value, nil
+}
+
+func TestLogEntryModes(t *testing.T) {
+	t.Run("Delta", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 250}}, logsampler.LogSampler{Mode: logsampler.ModeDelta})
+
+		first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Equal(t, uint64(100), first.Events[0].UsageBytes)
+		assert.Equal(t, uint64(150), second.Events[0].UsageBytes)
+		assert.Empty(t, second.Events[0].Mode)
+	})
+
+	t.Run("Cumulative", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 250, 50, 80}}, logsampler.LogSampler{Mode: logsampler.ModeCumulative})
+		clock := time.UnixMilli(10000)
+		builder.now = func() time.Time { return clock }
+		bootTime, bootID := time.UnixMilli(500), "boot-1"
+		builder.bootTime = func() (time.Time, error) { return bootTime, nil }
+		builder.bootID = func() (string, error) { return bootID, nil }
+
+		first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		clock = clock.Add(time.Second)
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		clock = clock.Add(time.Second)
+		reset := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		clock = clock.Add(time.Second)
+		bootTime, bootID = time.UnixMilli(12500), "boot-2"
+		rebooted := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Equal(t, logsampler.ModeCumulative, first.Events[0].Mode)
+		assert.Equal(t, uint64(100), first.Events[0].UsageBytes)
+		assert.Equal(t, int64(500), first.Events[0].StartTimestamp, "The counter should start at the boot time")
+		assert.Equal(t, uint64(250), second.Events[0].UsageBytes)
+		assert.Equal(t, int64(500), second.Events[0].StartTimestamp)
+		assert.Equal(t, uint64(50), reset.Events[0].UsageBytes)
+		assert.Equal(t, int64(11000), reset.Events[0].StartTimestamp, "A reset counter should start after the last sample")
+		assert.Equal(t, uint64(80), rebooted.Events[0].UsageBytes)
+		assert.Equal(t, int64(12500), rebooted.Events[0].StartTimestamp, "A counter read on a new boot should start at the new boot time")
+	})
+
+	t.Run("Gauge", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 250}}, logsampler.LogSampler{Mode: logsampler.ModeGauge})
+
+		emitLogEntry(t, builder, time.Now())
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Equal(t, logsampler.ModeGauge, second.Events[0].Mode)
+		assert.Equal(t, uint64(250), second.Events[0].UsageBytes)
+	})
+
+	t.Run("Rate", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 500}}, logsampler.LogSampler{Mode: logsampler.ModeRate})
+		clock := time.Now()
+		builder.now = func() time.Time { return clock }
+
+		first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		clock = clock.Add(4 * time.Second)
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Nil(t, first.Events[0].RatePerSecond)
+		assert.Equal(t, uint64(400), second.Events[0].UsageBytes)
+		assert.NotNil(t, second.Events[0].RatePerSecond)
+		assert.Equal(t, float64(100), *second.Events[0].RatePerSecond)
+	})
+
+	t.Run("Rate with sample interval", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 200, 450, 500}}, logsampler.LogSampler{Mode: logsampler.ModeRate, SampleInterval: time.Second})
+		clock := time.Now()
+		builder.now = func() time.Time { return clock }
+
+		emitLogEntry(t, builder, time.Now())
+		for i := 0; i < 2; i++ {
+			clock = clock.Add(time.Second)
+			builder.observe()
+		}
+		clock = clock.Add(2 * time.Second)
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Equal(t, uint64(400), second.Events[0].UsageBytes)
+		assert.Equal(t, float64(100), *second.Events[0].RatePerSecond, "The rate should cover the whole interval, not the last intermediate reading")
+	})
+}
+
+func TestLogEntryWindow(t *testing.T) {
+	t.Run("Without sample interval", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+
+		entry := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Nil(t, entry.Events[0].Window)
+	})
+
+	t.Run("With sample interval", func(t *testing.T) {
+		builder := newTestUsageEntryBuilder(t, &MockPersister{Data: make(map[string][]byte)}, &sequenceSampler{values: []uint64{100, 110, 150, 160, 1160}}, logsampler.LogSampler{SampleInterval: time.Second})
+		clock := time.Now()
+		builder.now = func() time.Time { return clock }
+
+		for i := 0; i < 4; i++ {
+			builder.observe()
+			clock = clock.Add(time.Second)
+		}
+		entry := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+		assert.Equal(t, uint64(1160), entry.Events[0].UsageBytes)
+		assert.Equal(t, &Window{
+			Samples:           4,
+			MinBytes:          10,
+			MaxBytes:          1000,
+			AvgBytes:          265,
+			P95Bytes:          1000,
+			PeakRatePerSecond: 1000,
+		}, entry.Events[0].Window)
+
+		clock = clock.Add(time.Second)
+		next := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+		assert.Equal(t, 1, next.Events[0].Window.Samples)
+	})
+}
+
+func TestLogEntryInterval(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+	builder.intervalStart = time.UnixMilli(5000)
+
+	first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(15000)))
+	second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+
+	assert.Equal(t, int64(5000), first.Events[0].IntervalStartMs)
+	assert.Equal(t, int64(15000), first.Events[0].IntervalEndMs)
+	assert.Equal(t, int64(15000), second.Events[0].IntervalStartMs)
+	assert.Equal(t, int64(30000), second.Events[0].IntervalEndMs)
+}
+
+func TestLogEntryPartial(t *testing.T) {
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{PollInterval: 15 * time.Second, AlignToInterval: true})
+	builder.intervalStart = time.UnixMilli(7000)
+
+	first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(15000)))
+	second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+
+	assert.True(t, first.Events[0].Partial, "The first aligned interval starts when the receiver started")
+	assert.False(t, second.Events[0].Partial)
+}
+
+func TestLogEntryRestart(t *testing.T) {
+	cfg := logsampler.LogSampler{PollInterval: 15 * time.Second, AlignToInterval: true}
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, cfg)
+	builder.intervalStart = time.UnixMilli(0)
+	builder.now = func() time.Time { return time.UnixMilli(15040) }
+	emitLogEntry(t, builder, time.UnixMilli(15000))
+	assert.Equal(t, []byte("15000"), mockPersister.Data[logsampler.IntervalEndKey])
+
+	restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+	restarted.intervalStart = time.UnixMilli(22000)
+	restarted.now = func() time.Time { return time.UnixMilli(30040) }
+	evt := unmarshalLogEntry(t, emitLogEntry(t, restarted, time.UnixMilli(30000))).Events[0]
+
+	assert.Equal(t, int64(15000), evt.IntervalStartMs, "The interval should resume where the last one ended, not at the last sample")
+	assert.False(t, evt.Partial)
+	assert.Equal(t, uint64(50), evt.UsageBytes)
+}
+
+func TestLogEntryWithoutStorage(t *testing.T) {
+	builder := newTestUsageEntryBuilder(t, storage.NewNopClient(), &sequenceSampler{values: []uint64{100, 150, 175}}, logsampler.LogSampler{})
+
+	first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+	second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+	third := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+	assert.True(t, first.Baseline)
+	assert.False(t, second.Baseline, "The last count should be kept in memory without a storage")
+	assert.Equal(t, []uint64{100, 50, 25}, []uint64{first.UsageBytes, second.UsageBytes, third.UsageBytes})
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{first.Sequence, second.Sequence, third.Sequence})
+}
+
+func TestLogEntrySequence(t *testing.T) {
+	mockPersister := &MockPersister{Data: make(map[string][]byte)}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+
+	first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+	second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now()))
+
+	// A new builder on the same storage continues the sequence, as after a restart
+	restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+	third := unmarshalLogEntry(t, emitLogEntry(t, restarted, time.Now()))
+
+	hostName, _ := os.Hostname()
+	assert.Equal(t, hostName, first.Events[0].HostName)
+	assert.Equal(t, uint64(1), first.Events[0].Sequence)
+	assert.Equal(t, uint64(2), second.Events[0].Sequence)
+	assert.Equal(t, uint64(3), third.Events[0].Sequence)
+}
+
+func TestLogEntryID(t *testing.T) {
+	newLogEntry := func(cfg logsampler.LogSampler, intervalStart int64, intervalEnd int64) Event {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, cfg)
+		builder.interfaceName = "eth0"
+		builder.intervalStart = time.UnixMilli(intervalStart)
+		return unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(intervalEnd))).Events[0]
+	}
+
+	t.Run("Deterministic", func(t *testing.T) {
+		cfg := logsampler.LogSampler{Metric: logsampler.MetricNetstats}
+
+		first := newLogEntry(cfg, 0, 20000)
+		replayed := newLogEntry(cfg, 0, 20000)
+		next := newLogEntry(cfg, 20000, 40000)
+
+		id, err := uuid.Parse(first.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, uuid.Version(5), id.Version())
+		assert.Equal(t, first.ID, replayed.ID)
+		assert.NotEqual(t, first.ID, next.ID)
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		cfg := logsampler.LogSampler{Metric: logsampler.MetricNetstats, IDMode: logsampler.IDModeRandom}
+
+		first := newLogEntry(cfg, 0, 20000)
+		replayed := newLogEntry(cfg, 0, 20000)
+
+		assert.NotEqual(t, first.ID, replayed.ID)
+	})
+}
+
+func TestLogEntryBaseline(t *testing.T) {
+	bootTime := time.UnixMilli(1000)
+
+	t.Run("Emit full", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: make(map[string][]byte)}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 150}}, logsampler.LogSampler{Baseline: logsampler.BaselineEmitFull})
+		builder.bootTime = func() (time.Time, error) { return bootTime, nil }
+
+		first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(20000)))
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(40000)))
+
+		assert.True(t, first.Events[0].Baseline)
+		assert.Equal(t, uint64(100), first.Events[0].UsageBytes)
+		assert.Equal(t, int64(1000), first.Events[0].IntervalStartMs)
+		assert.False(t, second.Events[0].Baseline)
+		assert.Equal(t, uint64(50), second.Events[0].UsageBytes)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: make(map[string][]byte)}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 150}}, logsampler.LogSampler{Baseline: logsampler.BaselineSkip})
+
+		first := emitLogEntry(t, builder, time.UnixMilli(20000))
+		second := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(40000)))
+
+		assert.Nil(t, first)
+		assert.Equal(t, []byte("150"), mockPersister.Data[logsampler.LastCountKey])
+		assert.False(t, second.Events[0].Baseline)
+		assert.Equal(t, uint64(50), second.Events[0].UsageBytes)
+		assert.Equal(t, int64(20000), second.Events[0].IntervalStartMs)
+	})
+
+	t.Run("Emit since process start", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: make(map[string][]byte)}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 130}}, logsampler.LogSampler{Baseline: logsampler.BaselineEmitSinceProcessStart})
+		builder.intervalStart = time.UnixMilli(5000)
+
+		first := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(20000)))
+
+		assert.True(t, first.Events[0].Baseline)
+		assert.Equal(t, uint64(30), first.Events[0].UsageBytes)
+		assert.Equal(t, int64(5000), first.Events[0].IntervalStartMs)
+	})
+}
+
+func TestLogEntryGap(t *testing.T) {
+	cfg := logsampler.LogSampler{PollInterval: 20 * time.Second}
+
+	newBuilder := func(persister *MockPersister, values ...uint64) *usageEntryBuilder {
+		builder := newTestUsageEntryBuilder(t, persister, &sequenceSampler{values: values}, cfg)
+		builder.intervalStart = time.UnixMilli(300000)
+		builder.now = func() time.Time { return time.UnixMilli(320000) }
+		return builder
+	}
+
+	t.Run("No gap within the threshold", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey:      []byte("50"),
+			logsampler.LastSampleTimeKey: []byte("300000"),
+		}}
+
+		written := emitLogEntries(t, newBuilder(mockPersister, 100), time.UnixMilli(320000))
+
+		assert.Len(t, written, 1)
+		assert.Equal(t, []byte("320000"), mockPersister.Data[logsampler.LastSampleTimeKey])
+	})
+
+	t.Run("Gap after a restart", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey:      []byte("50"),
+			logsampler.LastSampleTimeKey: []byte("100000"),
+		}}
+
+		written := emitLogEntries(t, newBuilder(mockPersister, 100), time.UnixMilli(320000))
+
+		assert.Len(t, written, 2)
+		var gapEntry GapLogEntry
+		assert.NoError(t, json.Unmarshal(written[0], &gapEntry))
+		usage := unmarshalLogEntry(t, written[1]).Events[0]
+
+		assert.Equal(t, logsampler.NetworkGapSchemaId, gapEntry.Metadata[logsampler.SchemaID])
+		gap := gapEntry.Events[0]
+		assert.NotEmpty(t, gap.ID)
+		assert.NotEqual(t, usage.ID, gap.ID)
+		assert.Equal(t, int64(100000), gap.GapStartMs)
+		assert.Equal(t, int64(320000), gap.GapEndMs)
+		assert.Equal(t, int64(220000), gap.DurationMs)
+		assert.True(t, gap.UsageSpansGap)
+		assert.Equal(t, usage.ID, gap.UsageEventID)
+
+		// The usage accumulated during the gap is accounted in the interval covering it
+		assert.Equal(t, uint64(50), usage.UsageBytes)
+		assert.Equal(t, int64(100000), usage.IntervalStartMs)
+	})
+
+	t.Run("Gap with a restarted counter", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey:      []byte("500"),
+			logsampler.LastSampleTimeKey: []byte("100000"),
+		}}
+
+		written := emitLogEntries(t, newBuilder(mockPersister, 100), time.UnixMilli(320000))
+
+		assert.Len(t, written, 2)
+		var gapEntry GapLogEntry
+		assert.NoError(t, json.Unmarshal(written[0], &gapEntry))
+		assert.False(t, gapEntry.Events[0].UsageSpansGap)
+		assert.Equal(t, int64(300000), unmarshalLogEntry(t, written[1]).Events[0].IntervalStartMs)
+	})
+
+	t.Run("Gap threshold", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey:      []byte("50"),
+			logsampler.LastSampleTimeKey: []byte("100000"),
+		}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{PollInterval: 20 * time.Second, GapThreshold: time.Hour})
+		builder.now = func() time.Time { return time.UnixMilli(320000) }
+
+		assert.Len(t, emitLogEntries(t, builder, time.UnixMilli(320000)), 1)
+	})
+
+	t.Run("Gaps disabled", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey:      []byte("50"),
+			logsampler.LastSampleTimeKey: []byte("100000"),
+		}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{PollInterval: 20 * time.Second, GapThreshold: -1})
+		builder.now = func() time.Time { return time.UnixMilli(320000) }
+
+		assert.Len(t, emitLogEntries(t, builder, time.UnixMilli(320000)), 1)
+	})
+}
+
+func TestLogEntrySuppressZero(t *testing.T) {
+	cfg := logsampler.LogSampler{PollInterval: 20 * time.Second, SuppressZero: true, HeartbeatInterval: time.Minute}
+
+	t.Run("Idle span covered by a heartbeat", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, cfg)
+		builder.intervalStart = time.UnixMilli(10000)
+
+		assert.Nil(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+		assert.Nil(t, emitLogEntry(t, builder, time.UnixMilli(50000)))
+		assert.Equal(t, []byte("10000"), mockPersister.Data[logsampler.IdleSinceKey])
+
+		heartbeat := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(70000)))
+
+		assert.Len(t, heartbeat.Events, 1)
+		assert.True(t, heartbeat.Events[0].Heartbeat)
+		assert.Equal(t, uint64(0), heartbeat.Events[0].UsageBytes)
+		assert.Equal(t, uint64(1), heartbeat.Events[0].Sequence)
+		assert.Equal(t, int64(10000), heartbeat.Events[0].IntervalStartMs)
+		assert.Equal(t, int64(70000), heartbeat.Events[0].IntervalEndMs)
+		assert.NotContains(t, mockPersister.Data, logsampler.IdleSinceKey)
+	})
+
+	t.Run("Idle span closed when the usage resumes", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 100, 150}}, cfg)
+		builder.intervalStart = time.UnixMilli(10000)
+
+		assert.Nil(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+		assert.Nil(t, emitLogEntry(t, builder, time.UnixMilli(50000)))
+		entry := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(70000)))
+
+		assert.Len(t, entry.Events, 2)
+		idle, usage := entry.Events[0], entry.Events[1]
+		assert.True(t, idle.Heartbeat)
+		assert.Equal(t, uint64(0), idle.UsageBytes)
+		assert.Equal(t, int64(10000), idle.IntervalStartMs)
+		assert.Equal(t, int64(50000), idle.IntervalEndMs)
+		assert.Equal(t, uint64(1), idle.Sequence)
+		assert.False(t, usage.Heartbeat)
+		assert.Equal(t, uint64(50), usage.UsageBytes)
+		assert.Equal(t, int64(50000), usage.IntervalStartMs)
+		assert.Equal(t, int64(70000), usage.IntervalEndMs)
+		assert.Equal(t, uint64(2), usage.Sequence)
+		assert.NotContains(t, mockPersister.Data, logsampler.IdleSinceKey)
+	})
+
+	t.Run("Idle span survives restarts", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, cfg)
+		builder.intervalStart = time.UnixMilli(10000)
+		assert.Nil(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+
+		restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, cfg)
+		restarted.intervalStart = time.UnixMilli(40000)
+		assert.Nil(t, emitLogEntry(t, restarted, time.UnixMilli(50000)))
+		heartbeat := unmarshalLogEntry(t, emitLogEntry(t, restarted, time.UnixMilli(70000)))
+
+		assert.Equal(t, int64(10000), heartbeat.Events[0].IntervalStartMs)
+	})
+
+	t.Run("Zero usage emitted without suppression", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100}}, logsampler.LogSampler{})
+
+		entry := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(30000)))
+
+		assert.Equal(t, uint64(0), entry.Events[0].UsageBytes)
+		assert.False(t, entry.Events[0].Heartbeat)
+	})
+}
+
+func TestLogEntryRollup(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return parsed
+	}
+
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	cfg := logsampler.LogSampler{Rollup: logsampler.RollupConfig{Enabled: true}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150, 175, 275}}, cfg)
+	builder.intervalStart = at("2024-05-31T23:30:00Z")
+
+	assert.Len(t, emitLogEntries(t, builder, at("2024-05-31T23:45:00Z")), 1)
+	assert.Len(t, emitLogEntries(t, builder, at("2024-06-01T00:00:00Z")), 1)
+	assert.Contains(t, mockPersister.Data, logsampler.RollupKey)
+
+	// The totals survive restarts
+	restarted := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{275}}, cfg)
+	restarted.intervalStart = at("2024-06-01T00:00:00Z")
+	written := emitLogEntries(t, restarted, at("2024-06-01T00:15:00Z"))
+
+	assert.Len(t, written, 2)
+	var summary RollupLogEntry
+	assert.NoError(t, json.Unmarshal(written[0], &summary))
+	assert.Equal(t, logsampler.NetworkRollupSchemaId, summary.Metadata[logsampler.SchemaID])
+	assert.Len(t, summary.Events, 2)
+
+	day := summary.Events[0]
+	assert.NotEmpty(t, day.ID)
+	assert.Equal(t, "day", day.Period)
+	assert.Equal(t, at("2024-05-31T00:00:00Z").UnixMilli(), day.PeriodStartMs)
+	assert.Equal(t, at("2024-06-01T00:00:00Z").UnixMilli(), day.PeriodEndMs)
+	assert.Equal(t, uint64(75), day.UsageBytes)
+	assert.Equal(t, 2, day.Intervals)
+	assert.Equal(t, "month", summary.Events[1].Period)
+	assert.Equal(t, uint64(75), summary.Events[1].UsageBytes)
+
+	assert.Equal(t, uint64(100), unmarshalLogEntry(t, written[1]).Events[0].UsageBytes)
+}
+
+func TestLogEntryAudit(t *testing.T) {
+	t.Run("Raw counter readings in the event", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey: []byte("100"),
+			logsampler.BootIDKey:    []byte("0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10"),
+		}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{Audit: true})
+		builder.bootID = func() (string, error) { return "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10", nil }
+		builder.source = netDevFile
+		builder.interfaceName = "eth0"
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+		assert.Equal(t, &Audit{
+			PreviousCounter: 100,
+			CurrentCounter:  150,
+			BootID:          "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10",
+			PreviousBootID:  "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10",
+			Source:          "/proc/net/dev",
+			Interface:       "eth0",
+		}, evt.Audit)
+		assert.Equal(t, evt.Audit.CurrentCounter-evt.Audit.PreviousCounter, evt.UsageBytes)
+	})
+
+	t.Run("Counter reset by a reboot", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey: []byte("100"),
+			logsampler.BootIDKey:    []byte("0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10"),
+		}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{50}}, logsampler.LogSampler{Audit: true})
+		builder.bootID = func() (string, error) { return "7d9e2a31-4c5b-4f6e-8a7d-1b2c3d4e5f60", nil }
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+		assert.Equal(t, "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10", evt.Audit.PreviousBootID)
+		assert.Equal(t, "7d9e2a31-4c5b-4f6e-8a7d-1b2c3d4e5f60", evt.Audit.BootID)
+		assert.Equal(t, resetReboot, evt.Audit.ResetReason)
+		assert.Equal(t, []byte("7d9e2a31-4c5b-4f6e-8a7d-1b2c3d4e5f60"), mockPersister.Data[logsampler.BootIDKey])
+	})
+
+	t.Run("Counter reset without a reboot", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey: []byte("100"),
+			logsampler.BootIDKey:    []byte("0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10"),
+		}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{50}}, logsampler.LogSampler{Audit: true})
+		builder.bootID = func() (string, error) { return "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10", nil }
+
+		assert.Equal(t, resetCounter, unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0].Audit.ResetReason)
+	})
+
+	t.Run("No audit by default", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{})
+
+		assert.Nil(t, unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0].Audit)
+	})
+}
+
+func TestLogEntryAnomaly(t *testing.T) {
+	t.Run("Spike and counter backwards", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{Anomaly: logsampler.AnomalyConfig{Enabled: true, SpikeFactor: 5}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{110, 120, 130, 1130, 50}}, cfg)
+
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0].Anomaly)
+		}
+
+		spike := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+		assert.Equal(t, uint64(1000), spike.UsageBytes)
+		assert.Equal(t, []string{anomaly.ReasonSpike}, spike.Anomaly.Reasons)
+		assert.Equal(t, uint64(10), spike.Anomaly.MedianBytes)
+
+		backwards := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+		assert.Equal(t, uint64(50), backwards.UsageBytes, "The usage should be counted from zero after a counter restart")
+		assert.Equal(t, []string{anomaly.ReasonCounterBackwards}, backwards.Anomaly.Reasons)
+	})
+
+	t.Run("Slow sample", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{Anomaly: logsampler.AnomalyConfig{Enabled: true, MaxSampleDuration: time.Second}}
+		clock := time.UnixMilli(20000)
+		builder := newTestUsageEntryBuilder(t, mockPersister, &slowSampler{value: 200, advance: func() { clock = clock.Add(3 * time.Second) }}, cfg)
+		builder.now = func() time.Time { return clock }
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(20000))).Events[0]
+
+		assert.Equal(t, []string{anomaly.ReasonSlowSample}, evt.Anomaly.Reasons)
+		assert.Equal(t, int64(3000), evt.Anomaly.SampleDurationMs)
+	})
+}
+
+// slowSampler is a mock implementation of sampler.Sampler which calls advance on each sample
+type slowSampler struct {
+	value   uint64
+	advance func()
+}
+
+func (s *slowSampler) Sample() (uint64, error) {
+	s.advance()
+	return s.value, nil
+}
+
+func TestLogEntryMetadata(t *testing.T) {
+	t.Setenv("POD_NAME", "mule-app-5")
+
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	cfg := logsampler.LogSampler{Metadata: map[string]identity.Source{
+		identity.OrgID:    {Value: "org"},
+		identity.Billable: {Value: "true"},
+		"pod_name":        {Env: "POD_NAME"},
+		identity.WorkerID: {From: "pod_name", Regex: `-(\d+)$`},
+	}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+
+	evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+	assert.Equal(t, "org", evt.OrgID)
+	assert.Equal(t, "5", evt.WorkerID)
+	assert.True(t, evt.Billable)
+}
+
+func TestLogEntryMetadataError(t *testing.T) {
+	t.Run("Invalid metadata", func(t *testing.T) {
+		cfg := logsampler.LogSampler{Metadata: map[string]identity.Source{identity.OrgID: {}}}
+
+		_, err := newUsageEntryBuilder(&MockPersister{Data: map[string][]byte{}}, &sequenceSampler{values: []uint64{150}}, cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("Interval left open until the metadata resolves", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{
+			logsampler.LastCountKey: []byte("100"),
+			logsampler.SequenceKey:  []byte("4"),
+		}}
+		orgFile := filepath.Join(t.TempDir(), "org_id")
+		cfg := logsampler.LogSampler{Metadata: map[string]identity.Source{identity.OrgID: {File: orgFile, Required: true}}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+		builder.intervalStart = time.UnixMilli(10000)
+
+		err := builder.emit(context.Background(), time.UnixMilli(30000), func(record samplerRecord) error {
+			assert.Fail(t, "No event should be emitted without the identity fields")
+			return nil
+		})
+		assert.Error(t, err)
+		assert.Equal(t, []byte("100"), mockPersister.Data[logsampler.LastCountKey])
+		assert.Equal(t, []byte("4"), mockPersister.Data[logsampler.SequenceKey])
+
+		assert.NoError(t, os.WriteFile(orgFile, []byte("org"), 0o600))
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.UnixMilli(50000))).Events[0]
+
+		assert.Equal(t, "org", evt.OrgID)
+		assert.Equal(t, uint64(50), evt.UsageBytes)
+		assert.Equal(t, int64(10000), evt.IntervalStartMs)
+	})
+}
+
+func TestLogEntryBillingRules(t *testing.T) {
+	t.Setenv("MULE_BILLING_ENABLED", "true")
+
+	cfg := logsampler.LogSampler{BillingRules: []billing.Rule{
+		{Name: "internal", Billable: false, Interfaces: []string{"lo", "flannel*"}},
+	}}
+
+	t.Run("Matching rule", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+		builder.interfaceName = "lo"
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+		assert.False(t, evt.Billable)
+		assert.Equal(t, "internal", evt.BillingRule)
+	})
+
+	t.Run("Default billability", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+		builder.interfaceName = "eth0"
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+		assert.True(t, evt.Billable)
+		assert.Equal(t, billing.DefaultRule, evt.BillingRule)
+	})
+
+	t.Run("No rule without billing rules", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{})
+
+		evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+		assert.True(t, evt.Billable)
+		assert.Empty(t, evt.BillingRule)
+	})
+}
+
+func TestLogEntrySchemaVersion(t *testing.T) {
+	t.Run("v2", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{Metric: logsampler.MetricNetstats, SchemaVersion: logsampler.SchemaV2}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+		builder.interfaceName = "eth0"
+
+		var logEntry struct {
+			Format string `json:"format"`
+			Events []struct {
+				Event
+				Interface string `json:"interface"`
+				Direction string `json:"direction"`
+			} `json:"events"`
+			Metadata map[string]string `json:"metadata"`
+		}
+		assert.NoError(t, json.Unmarshal(emitLogEntry(t, builder, time.Now()), &logEntry))
+
+		assert.Equal(t, "v2", logEntry.Format)
+		assert.Equal(t, logsampler.NetworkV2SchemaId, logEntry.Metadata[logsampler.SchemaID])
+		assert.Equal(t, uint64(50), logEntry.Events[0].UsageBytes)
+		assert.Equal(t, "eth0", logEntry.Events[0].Interface)
+		assert.Equal(t, billing.DirectionTotal, logEntry.Events[0].Direction)
+		assert.NotZero(t, logEntry.Events[0].IntervalEndMs)
+	})
+
+	t.Run("v1 by default", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{Metric: logsampler.MetricNetstats})
+
+		jsonEntry := emitLogEntry(t, builder, time.Now())
+
+		assert.Equal(t, "v1", unmarshalLogEntry(t, jsonEntry).Format)
+		assert.Equal(t, logsampler.NetworkSchemaId, unmarshalLogEntry(t, jsonEntry).Metadata[logsampler.SchemaID])
+		assert.NotContains(t, string(jsonEntry), `"interface"`)
+	})
+}
+
+func TestLogEntryTemplate(t *testing.T) {
+	emit := func(t *testing.T, template string) []byte {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		cfg := logsampler.LogSampler{Template: template, IDMode: logsampler.IDModeDeterministic}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, cfg)
+		builder.hostName = "host"
+		builder.now = func() time.Time { return time.UnixMilli(1714564800000) }
+		builder.intervalStart = time.UnixMilli(1714564780000)
+		return emitLogEntry(t, builder, time.UnixMilli(1714564800000))
+	}
+
+	t.Run("Default template", func(t *testing.T) {
+		assert.Equal(t, string(emit(t, "")), string(emit(t, render.Default)))
+	})
+
+	t.Run("Custom shape", func(t *testing.T) {
+		rendered := emit(t, `{"usage": [{{ range .Events }}{"bytes": {{ .UsageBytes }}, "from": {{ json (timestamp .IntervalStartMs) }}, "schema": {{ json (index $.Metadata "schema_id") }}}{{ end }}]}`)
+
+		assert.Equal(t, `{"usage":[{"bytes":50,"from":"2024-05-01T11:59:40Z","schema":"network_schema_id"}]}`, string(rendered))
+	})
+
+	t.Run("Serialized as it is when the template fails", func(t *testing.T) {
+		rendered := emit(t, `{"bytes": {{ .Unknown }}}`)
+
+		assert.Equal(t, string(emit(t, "")), string(rendered))
+	})
+
+	t.Run("Template failures logged and counted", func(t *testing.T) {
+		mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+		builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{Template: `{"bytes": {{ .Unknown }}}`})
+		core, logs := observer.New(zap.WarnLevel)
+		builder.logger = zap.New(core)
+		failures := &countingCounter{}
+		builder.renderFailures = failures
+
+		emitLogEntry(t, builder, time.Now())
+
+		assert.Equal(t, 1, logs.Len())
+		assert.Equal(t, int64(1), failures.count)
+	})
+}
+
+// countingCounter is an implementation of metric.Int64Counter which sums the increments
+type countingCounter struct {
+	noop.Int64Counter
+	count int64
+}
+
+func (c *countingCounter) Add(_ context.Context, incr int64, _ ...metric.AddOption) {
+	c.count += incr
+}
+
+func TestLogEntryPodInfo(t *testing.T) {
+	directory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "labels"), []byte("tier=\"gold\"\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "namespace"), []byte("apps"), 0600))
+
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+	builder := newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{150}}, logsampler.LogSampler{})
+	builder.attributes = podinfo.New(podinfo.Config{Enabled: true, Directory: directory, Labels: []string{"tier"}}).Attributes
+
+	evt := unmarshalLogEntry(t, emitLogEntry(t, builder, time.Now())).Events[0]
+
+	assert.Equal(t, map[string]string{
+		podinfo.NamespaceAttribute:            "apps",
+		podinfo.LabelAttributePrefix + "tier": "gold",
+	}, evt.Attributes)
+}
+
+func TestPipelineConsumerSamplerEmitterOutbox(t *testing.T) {
+	ctx := context.Background()
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("50")}}
+	samplerOutbox, err := outbox.New(ctx, mockPersister, 10, 0, noop.NewMeterProvider().Meter("test"))
+	assert.NoError(t, err)
+
+	output := &captureOperator{}
+	input := &file.Input{}
+	input.OutputOperators = []operator.Operator{output}
+
+	emitter := PipelineConsumerSamplerEmitter{
+		entryBuilder: newTestUsageEntryBuilder(t, mockPersister, &sequenceSampler{values: []uint64{100, 150}}, logsampler.LogSampler{}),
+		input:        input,
+		outbox:       samplerOutbox,
+	}
+
+	assert.NoError(t, emitter.Emit(ctx, time.UnixMilli(20000)))
+
+	assert.Len(t, output.entries, 1)
+	firstID := output.entries[0].Attributes[samplerOutboxIDAttribute]
+	assert.NotNil(t, firstID)
+	assert.Equal(t, 1, samplerOutbox.Len())
+	assert.Equal(t, []byte("100"), mockPersister.Data[logsampler.LastCountKey], "The checkpoint is committed once the entry is in the outbox")
+
+	// The pipeline did not accept the first entry, so it is delivered again before the next one
+	samplerOutbox.Nack([]uint64{firstID.(uint64)})
+	assert.NoError(t, emitter.Emit(ctx, time.UnixMilli(40000)))
+
+	assert.Len(t, output.entries, 3)
+	assert.Equal(t, output.entries[0].Body, output.entries[1].Body)
+	assert.Equal(t, firstID, output.entries[1].Attributes[samplerOutboxIDAttribute])
+	assert.Equal(t, 2, samplerOutbox.Len())
+}
+
+func TestPipelineConsumerSamplerEmitterSeverity(t *testing.T) {
+	output := &captureOperator{}
+	input := &file.Input{}
+	input.OutputOperators = []operator.Operator{output}
+	emitter := PipelineConsumerSamplerEmitter{input: input}
+
+	assert.NoError(t, emitter.writer(context.Background())(samplerRecord{Body: []byte(`{"events":[]}`), Severity: entry.Warn}))
+
+	assert.Len(t, output.entries, 1)
+	assert.Equal(t, `{"events":[]}`, output.entries[0].Body)
+	assert.Equal(t, entry.Warn, output.entries[0].Severity)
+}
+
+func TestPipelineConsumerSamplerEmitterEncoding(t *testing.T) {
+	emit := func(t *testing.T, encoding string) any {
+		output := &captureOperator{}
+		input := &file.Input{}
+		input.OutputOperators = []operator.Operator{output}
+		entryEncoder, err := encoder.New(encoding)
+		assert.NoError(t, err)
+		emitter := PipelineConsumerSamplerEmitter{input: input, encoder: entryEncoder}
+
+		body := `{"format":"v1","time":1,"events":[{"id":"a1","usage_bytes":50}],"metadata":{"schema_id":"network_schema_id"}}`
+		assert.NoError(t, emitter.writer(context.Background())(samplerRecord{Body: []byte(body)}))
+
+		assert.Len(t, output.entries, 1)
+		return output.entries[0].Body
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		assert.IsType(t, "", emit(t, encoder.JSON))
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		body := emit(t, encoder.CSV)
+
+		assert.IsType(t, "", body)
+		assert.True(t, strings.HasPrefix(body.(string), "network_schema_id,v1,1,a1,"))
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		assert.IsType(t, []byte{}, emit(t, encoder.Protobuf))
+	})
+
+	t.Run("CloudEvents", func(t *testing.T) {
+		body := emit(t, encoder.CloudEvents)
+
+		assert.IsType(t, "", body)
+		assert.Contains(t, body, `"specversion":"1.0","id":"a1"`)
+	})
+}
+
+func TestPipelineConsumerSamplerEmitterRecordFormat(t *testing.T) {
+	body := `{"format":"v1","time":1714564800000,"events":[` +
+		`{"id":"a1","org_id":"org","env_id":"env","asset_id":"app","usage_bytes":9007199254740993,"interval_end_ms":1714564790000},` +
+		`{"id":"a2","org_id":"org","env_id":"env","asset_id":"app","usage_bytes":10,"interval_end_ms":1714564795000,"rate_per_second":0.5}` +
+		`],"metadata":{"schema_id":"network_schema_id"}}`
+
+	emit := func(t *testing.T, format string, severity entry.Severity) []*entry.Entry {
+		output := &captureOperator{}
+		input := &file.Input{}
+		input.OutputOperators = []operator.Operator{output}
+		emitter := PipelineConsumerSamplerEmitter{input: input, recordFormat: format}
+
+		assert.NoError(t, emitter.writer(context.Background())(samplerRecord{Body: []byte(body), Severity: severity}))
+		return output.entries
+	}
+
+	t.Run("String", func(t *testing.T) {
+		entries := emit(t, logsampler.RecordFormatString, entry.Default)
+
+		assert.Len(t, entries, 1)
+		assert.Equal(t, body, entries[0].Body)
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		entries := emit(t, logsampler.RecordFormatMap, entry.Default)
+
+		assert.Len(t, entries, 1)
+		ent := entries[0]
+		assert.Equal(t, time.UnixMilli(1714564800000), ent.Timestamp)
+		assert.False(t, ent.ObservedTimestamp.IsZero())
+		assert.Equal(t, entry.Info, ent.Severity)
+		assert.Equal(t, "network_schema_id", ent.Attributes[eventNameAttribute])
+		assert.Equal(t, map[string]any{"org_id": "org", "env_id": "env", "asset_id": "app"}, ent.Resource)
+
+		events := ent.Body.(map[string]any)["events"].([]any)
+		assert.Len(t, events, 2)
+		assert.Equal(t, int64(9007199254740993), events[0].(map[string]any)["usage_bytes"], "The counters keep their precision")
+	})
+
+	t.Run("Event", func(t *testing.T) {
+		entries := emit(t, logsampler.RecordFormatEvent, entry.Warn)
+
+		assert.Len(t, entries, 2)
+		assert.Equal(t, time.UnixMilli(1714564790000), entries[0].Timestamp)
+		assert.Equal(t, time.UnixMilli(1714564795000), entries[1].Timestamp)
+		assert.Equal(t, entry.Warn, entries[1].Severity)
+		assert.Equal(t, "network_schema_id", entries[1].Attributes[eventNameAttribute])
+		assert.Equal(t, "app", entries[1].Resource["asset_id"])
+		assert.Equal(t, map[string]any{
+			"id":              "a2",
+			"org_id":          "org",
+			"env_id":          "env",
+			"asset_id":        "app",
+			"usage_bytes":     int64(10),
+			"interval_end_ms": int64(1714564795000),
+			"rate_per_second": 0.5,
+		}, entries[1].Body)
+	})
+
+	t.Run("Identity fields merged into the configured resource", func(t *testing.T) {
+		identifier, err := helper.IdentifierConfig{Resource: map[string]helper.ExprStringConfig{"service.name": "worker"}}.Build()
+		assert.NoError(t, err)
+		output := &captureOperator{}
+		input := &file.Input{}
+		input.Identifier = identifier
+		input.OutputOperators = []operator.Operator{output}
+		emitter := PipelineConsumerSamplerEmitter{input: input, recordFormat: logsampler.RecordFormatEvent}
+
+		assert.NoError(t, emitter.writer(context.Background())(samplerRecord{Body: []byte(body)}))
+
+		assert.Len(t, output.entries, 2)
+		assert.Equal(t, map[string]any{"service.name": "worker", "org_id": "org", "env_id": "env", "asset_id": "app"}, output.entries[0].Resource)
+	})
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		input := &file.Input{}
+		input.OutputOperators = []operator.Operator{&captureOperator{}}
+		emitter := PipelineConsumerSamplerEmitter{input: input, recordFormat: logsampler.RecordFormatEvent}
+
+		assert.Error(t, emitter.writer(context.Background())(samplerRecord{Body: []byte("not json")}))
+	})
+}
+
+func TestFileLoggerSamplerEmitterEncoding(t *testing.T) {
+	setRequiredIdentity(t)
+	uri := filepath.Join(t.TempDir(), "usage.csv")
+	mockPersister := &MockPersister{Data: map[string][]byte{logsampler.LastCountKey: []byte("100")}}
+
+	samplerEmitter, err := SamplerEmitterFactory(componenttest.NewNopTelemetrySettings(), logsampler.LogSampler{Output: logsampler.OutputFileLogger, URI: uri, Encoding: encoder.CSV}, mockPersister, nil, nil, nil, nil, nil)
+	assert.NoError(t, err)
+	emitter := samplerEmitter.(FileLoggerSamplerEmitter)
+	emitter.entryBuilder.sampler = &sequenceSampler{values: []uint64{150, 175}}
+
+	assert.NoError(t, emitter.Emit(context.Background(), time.Now()))
+	assert.NoError(t, emitter.Emit(context.Background(), time.Now()))
+
+	content, err := os.ReadFile(uri)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Len(t, lines, 3, "A header and a row per event")
+	assert.True(t, strings.HasPrefix(lines[0], "schema_id,format,time,id,"))
+}
+
+// captureOperator is an output operator which captures the processed entries
+type captureOperator struct {
+	helper.OutputOperator
+	entries []*entry.Entry
+}
+
+func (o *captureOperator) Process(_ context.Context, e *entry.Entry) error {
+	o.entries = append(o.entries, e)
+	return nil
+}
+
+// newTestUsageEntryBuilder creates a usage entry builder, failing the test if the config is invalid.
+func newTestUsageEntryBuilder(t *testing.T, persister operator.Persister, samp sampler.Sampler, cfg logsampler.LogSampler) *usageEntryBuilder {
+	setRequiredIdentity(t)
+	builder, err := newUsageEntryBuilder(persister, samp, cfg)
+	assert.NoError(t, err)
+	return builder
+}
+
+// setRequiredIdentity sets the env vars of the identity fields required by default, which the
+// events are not emitted without.
+func setRequiredIdentity(t *testing.T) {
+	t.Setenv(identity.EnvOrgID, "org")
+	t.Setenv(identity.EnvEnvID, "env")
+	t.Setenv(identity.EnvDeploymentID, "app")
+}
+
+// emitLogEntry emits the entries of the interval ending at intervalEnd and returns the last written
+// entry, which is the usage entry, or nil if no entry was written.
+func emitLogEntry(t *testing.T, builder *usageEntryBuilder, intervalEnd time.Time) []byte {
+	written := emitLogEntries(t, builder, intervalEnd)
+	if len(written) == 0 {
+		return nil
+	}
+	return written[len(written)-1]
+}
+
+// emitLogEntries emits the entries of the interval ending at intervalEnd and returns the written entries.
+func emitLogEntries(t *testing.T, builder *usageEntryBuilder, intervalEnd time.Time) [][]byte {
+	var written [][]byte
+	err := builder.emit(context.Background(), intervalEnd, func(record samplerRecord) error {
+		written = append(written, record.Body)
+		return nil
+	})
+	assert.NoError(t, err)
+	return written
+}
+
+func unmarshalLogEntry(t *testing.T, jsonEntry []byte) LogEntry {
+	var logEntry LogEntry
+	assert.NoError(t, json.Unmarshal(jsonEntry, &logEntry))
+	return logEntry
+}
+
+// sequenceSampler is a mock implementation of sampler.Sampler that returns the given values in order
+type sequenceSampler struct {
+	values []uint64
+}
+
+func (s *sequenceSampler) Sample() (uint64, error) {
+	value := s.values[0]
+	if len(s.values) > 1 {
+		s.values = s.values[1:]
+	}
+	return value, nil
+}
+
 // MockPersister is a mock implementation of Persister interface for testing.
 type MockPersister struct {
 	Data map[string][]byte // Store data for testing
@@ -0,0 +1,123 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/encoder"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/logsampler"
+)
+
+// eventNameAttribute is the attribute holding the name of the event of a structured record, which
+// is the schema ID of its entry.
+const eventNameAttribute = "event.name"
+
+// structuredRecord is a log record of a sampler entry with a map body.
+type structuredRecord struct {
+	body      map[string]any
+	timestamp time.Time
+	eventName string
+	// resource holds the identity fields of the events of the record
+	resource map[string]string
+}
+
+// structuredRecords decodes the serialized entry into records of the given format: one record for
+// the entry, or one per event.
+func structuredRecords(data []byte, format string) ([]structuredRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded map[string]any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("decode entry: %w", err)
+	}
+	body := normalize(decoded).(map[string]any)
+
+	var events []map[string]any
+	if list, ok := body["events"].([]any); ok {
+		for _, evt := range list {
+			if evt, ok := evt.(map[string]any); ok {
+				events = append(events, evt)
+			}
+		}
+	}
+
+	var eventName string
+	if metadata, ok := body["metadata"].(map[string]any); ok {
+		eventName, _ = metadata["schema_id"].(string)
+	}
+	entryTime, _ := body["time"].(int64)
+
+	if format == logsampler.RecordFormatEvent {
+		records := make([]structuredRecord, 0, len(events))
+		for _, evt := range events {
+			records = append(records, structuredRecord{
+				body:      evt,
+				timestamp: eventTime(evt, entryTime),
+				eventName: eventName,
+				resource:  identityFields(evt),
+			})
+		}
+		return records, nil
+	}
+
+	record := structuredRecord{
+		body:      body,
+		timestamp: time.UnixMilli(entryTime),
+		eventName: eventName,
+	}
+	if len(events) > 0 {
+		// The events of an entry belong to the same worker
+		record.resource = identityFields(events[0])
+	}
+	return []structuredRecord{record}, nil
+}
+
+// eventTime returns the end of the time the event covers, or the time of its entry if the event has none.
+func eventTime(evt map[string]any, entryTime int64) time.Time {
+	for _, name := range encoder.TimeFields {
+		if ms, ok := evt[name].(int64); ok && ms != 0 {
+			return time.UnixMilli(ms)
+		}
+	}
+	return time.UnixMilli(entryTime)
+}
+
+// identityFields returns the identity fields of the event which are set.
+func identityFields(evt map[string]any) map[string]string {
+	fields := map[string]string{}
+	for _, field := range identity.ResourceFields {
+		if value, ok := evt[field].(string); ok && value != "" {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// normalize converts the numbers of a value decoded with UseNumber into int64, or float64 if they
+// are not integers, so that the counters keep their precision.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
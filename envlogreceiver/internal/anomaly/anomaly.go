@@ -0,0 +1,113 @@
+package anomaly
+
+import (
+	"sort"
+	"time"
+)
+
+// Constants for the reasons of an anomaly
+const (
+	// ReasonSpike is a usage above the spike factor times the rolling median of the recent usages
+	ReasonSpike = "spike"
+	// ReasonCounterBackwards is a counter lower than the previous reading, e.g. after a reboot
+	ReasonCounterBackwards = "counter_backwards"
+	// ReasonSlowSample is a sample which took longer than the maximum sample duration
+	ReasonSlowSample = "slow_sample"
+)
+
+// Defaults used when the configuration values are not set
+const (
+	DefaultSpikeFactor       = 10
+	DefaultMedianWindow      = 10
+	DefaultMaxSampleDuration = time.Second
+
+	// minSamples is the number of usages needed to compute a meaningful rolling median
+	minSamples = 3
+)
+
+// Config represents the configuration of a Detector.
+type Config struct {
+	// SpikeFactor is the factor of the rolling median above which a usage is a spike.
+	SpikeFactor float64
+	// MedianWindow is the number of recent usages the rolling median is computed on.
+	MedianWindow int
+	// MaxSampleDuration is the duration above which a sample is slow.
+	MaxSampleDuration time.Duration
+}
+
+// Sample is the outcome of a sample checked by a Detector.
+type Sample struct {
+	// Usage is the usage since the previous sample
+	Usage uint64
+	// CounterBackwards tells whether the counter was lower than the previous reading
+	CounterBackwards bool
+	// Duration is the time taken to read the counter
+	Duration time.Duration
+}
+
+// Result is the outcome of checking a sample.
+type Result struct {
+	// Reasons are the reasons the sample is suspicious. It is empty when the sample is not.
+	Reasons []string
+	// Median is the rolling median of the usages before the sample
+	Median uint64
+}
+
+// Detector flags suspicious samples: usage spikes compared to the rolling median of the recent
+// usages, counters moving backwards and slow samples. It keeps the recent usages in
+// memory only, so spikes are not detected until enough usages are seen after a start.
+type Detector struct {
+	cfg    Config
+	recent []uint64
+}
+
+// New creates a detector, using the defaults for the configuration values which are not set.
+func New(cfg Config) *Detector {
+	if cfg.SpikeFactor <= 0 {
+		cfg.SpikeFactor = DefaultSpikeFactor
+	}
+	if cfg.MedianWindow <= 0 {
+		cfg.MedianWindow = DefaultMedianWindow
+	}
+	if cfg.MaxSampleDuration <= 0 {
+		cfg.MaxSampleDuration = DefaultMaxSampleDuration
+	}
+
+	return &Detector{cfg: cfg}
+}
+
+// Check checks the sample and adds its usage to the recent usages, unless the counter moved
+// backwards, since the usage is not comparable then.
+func (d *Detector) Check(sample Sample) Result {
+	var result Result
+
+	if sample.CounterBackwards {
+		result.Reasons = append(result.Reasons, ReasonCounterBackwards)
+	}
+	if sample.Duration > d.cfg.MaxSampleDuration {
+		result.Reasons = append(result.Reasons, ReasonSlowSample)
+	}
+
+	if len(d.recent) >= minSamples {
+		result.Median = median(d.recent)
+		if result.Median > 0 && float64(sample.Usage) > d.cfg.SpikeFactor*float64(result.Median) {
+			result.Reasons = append(result.Reasons, ReasonSpike)
+		}
+	}
+
+	if !sample.CounterBackwards {
+		d.recent = append(d.recent, sample.Usage)
+		if len(d.recent) > d.cfg.MedianWindow {
+			d.recent = d.recent[len(d.recent)-d.cfg.MedianWindow:]
+		}
+	}
+
+	return result
+}
+
+// median returns the median of the values, the lower one for an even number of values.
+func median(values []uint64) uint64 {
+	sorted := append([]uint64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)-1)/2]
+}
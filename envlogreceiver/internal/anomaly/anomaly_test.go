@@ -0,0 +1,47 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetector(t *testing.T) {
+	t.Run("Spike above the rolling median", func(t *testing.T) {
+		detector := New(Config{SpikeFactor: 5, MedianWindow: 3})
+
+		// Not enough usages for a median yet
+		assert.Empty(t, detector.Check(Sample{Usage: 100}).Reasons)
+		assert.Empty(t, detector.Check(Sample{Usage: 120}).Reasons)
+		assert.Empty(t, detector.Check(Sample{Usage: 5000}).Reasons)
+
+		result := detector.Check(Sample{Usage: 1000})
+		assert.Equal(t, uint64(120), result.Median)
+		assert.Equal(t, []string{ReasonSpike}, result.Reasons)
+
+		// The window only keeps the most recent usages
+		result = detector.Check(Sample{Usage: 1000})
+		assert.Equal(t, uint64(1000), result.Median)
+		assert.Empty(t, result.Reasons)
+	})
+
+	t.Run("No spike over a zero median", func(t *testing.T) {
+		detector := New(Config{})
+		for i := 0; i < 3; i++ {
+			detector.Check(Sample{Usage: 0})
+		}
+
+		assert.Empty(t, detector.Check(Sample{Usage: 1000}).Reasons)
+	})
+
+	t.Run("Counter backwards and slow samples", func(t *testing.T) {
+		detector := New(Config{MaxSampleDuration: 100 * time.Millisecond})
+
+		assert.Equal(t, []string{ReasonCounterBackwards}, detector.Check(Sample{Usage: 10, CounterBackwards: true}).Reasons)
+		assert.Equal(t, []string{ReasonSlowSample}, detector.Check(Sample{Usage: 10, Duration: time.Second}).Reasons)
+
+		// Only the comparable usages are kept
+		assert.Equal(t, []uint64{10}, detector.recent)
+	})
+}
@@ -0,0 +1,262 @@
+package billing
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DirectionTotal is the direction of the usage of both directions, the only one measured
+const DirectionTotal = "total"
+
+// DefaultRule is the rule recorded for the events no rule matched, whose billability is the default one
+const DefaultRule = "default"
+
+// timeOfDayLayout is the layout of the start and end of the time windows
+const timeOfDayLayout = "15:04"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Rule decides the billability of the events it matches. An event is matched when it matches all
+// the conditions which are set.
+type Rule struct {
+	// Name identifies the rule in the events it matched.
+	Name string `mapstructure:"name"`
+	// Billable is the billability of the events matched.
+	Billable bool `mapstructure:"billable"`
+	// Interfaces are the glob patterns of the names of the network interfaces matched.
+	Interfaces []string `mapstructure:"interfaces,omitempty"`
+	// Directions are the directions of the usage matched.
+	Directions []string `mapstructure:"directions,omitempty"`
+	// OrgIDs are the organization IDs matched.
+	OrgIDs []string `mapstructure:"org_ids,omitempty"`
+	// EnvIDs are the environment IDs matched.
+	EnvIDs []string `mapstructure:"env_ids,omitempty"`
+	// AssetID is the regular expression of the deployment IDs matched.
+	AssetID string `mapstructure:"asset_id,omitempty"`
+	// TimeWindows are the windows of time of day matched.
+	TimeWindows []TimeWindow `mapstructure:"time_windows,omitempty"`
+}
+
+// TimeWindow is a window of time of day, which spans midnight when its end is before its start.
+type TimeWindow struct {
+	// Start is the time of day the window starts at, as HH:MM.
+	Start string `mapstructure:"start"`
+	// End is the time of day the window ends at, excluded, as HH:MM.
+	End string `mapstructure:"end"`
+	// Days are the days of the week the window starts on, as mon, tue, ... All days when empty.
+	Days []string `mapstructure:"days,omitempty"`
+	// Timezone is the IANA time zone of the window. UTC when empty.
+	Timezone string `mapstructure:"timezone,omitempty"`
+}
+
+// Event holds the attributes of a usage event the rules are matched against.
+type Event struct {
+	Interface string
+	Direction string
+	OrgID     string
+	EnvID     string
+	AssetID   string
+	// Time is the end of the interval of the event
+	Time time.Time
+}
+
+// Result is the billability of an event along with the rule which decided it.
+type Result struct {
+	Rule     string
+	Billable bool
+}
+
+type compiledRule struct {
+	Rule
+	assetID *regexp.Regexp
+	windows []compiledWindow
+}
+
+type compiledWindow struct {
+	start    time.Duration
+	end      time.Duration
+	days     map[time.Weekday]bool
+	location *time.Location
+}
+
+// Classifier decides the billability of the events with the first rule they match.
+type Classifier struct {
+	rules []compiledRule
+}
+
+// New creates a classifier of the given rules, which are matched in order. It fails if a rule has
+// no name, a duplicated name or an invalid condition.
+func New(rules []Rule) (*Classifier, error) {
+	c := &Classifier{}
+	names := map[string]bool{}
+
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Name == DefaultRule || names[rule.Name] {
+			return nil, fmt.Errorf("each billing rule must have a unique name other than %s", DefaultRule)
+		}
+		names[rule.Name] = true
+
+		compiled := compiledRule{Rule: rule}
+
+		for _, pattern := range rule.Interfaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("billing rule %s: interface %s: %w", rule.Name, pattern, err)
+			}
+		}
+
+		// The usage is only measured for both directions, so a rule on another direction would never match
+		for _, direction := range rule.Directions {
+			if direction != DirectionTotal {
+				return nil, fmt.Errorf("billing rule %s: incorrect direction %s. Possible Values: [%s]", rule.Name, direction, DirectionTotal)
+			}
+		}
+
+		if rule.AssetID != "" {
+			assetID, err := regexp.Compile(rule.AssetID)
+			if err != nil {
+				return nil, fmt.Errorf("billing rule %s: asset_id: %w", rule.Name, err)
+			}
+			compiled.assetID = assetID
+		}
+
+		for _, window := range rule.TimeWindows {
+			compiledWindow, err := compileWindow(window)
+			if err != nil {
+				return nil, fmt.Errorf("billing rule %s: time window: %w", rule.Name, err)
+			}
+			compiled.windows = append(compiled.windows, compiledWindow)
+		}
+
+		c.rules = append(c.rules, compiled)
+	}
+
+	return c, nil
+}
+
+func compileWindow(window TimeWindow) (compiledWindow, error) {
+	start, err := parseTimeOfDay(window.Start)
+	if err != nil {
+		return compiledWindow{}, err
+	}
+	end, err := parseTimeOfDay(window.End)
+	if err != nil {
+		return compiledWindow{}, err
+	}
+
+	location, err := time.LoadLocation(window.Timezone)
+	if err != nil {
+		return compiledWindow{}, err
+	}
+
+	compiled := compiledWindow{start: start, end: end, location: location}
+	if len(window.Days) > 0 {
+		compiled.days = map[time.Weekday]bool{}
+		for _, day := range window.Days {
+			weekday, found := weekdays[strings.ToLower(day)]
+			if !found {
+				return compiledWindow{}, fmt.Errorf("incorrect day %s", day)
+			}
+			compiled.days[weekday] = true
+		}
+	}
+	return compiled, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse(timeOfDayLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect time of day %s, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Classify returns the billability of the event decided by the first rule it matches, or the
+// default billability, with DefaultRule, if it matches none.
+func (c *Classifier) Classify(evt Event, defaultBillable bool) Result {
+	for _, rule := range c.rules {
+		if rule.matches(evt) {
+			return Result{Rule: rule.Name, Billable: rule.Billable}
+		}
+	}
+	return Result{Rule: DefaultRule, Billable: defaultBillable}
+}
+
+func (r compiledRule) matches(evt Event) bool {
+	if len(r.Interfaces) > 0 && !matchesAny(r.Interfaces, evt.Interface, func(pattern string, value string) bool {
+		matched, _ := path.Match(pattern, value)
+		return matched
+	}) {
+		return false
+	}
+	if len(r.Directions) > 0 && !matchesAny(r.Directions, evt.Direction, equals) {
+		return false
+	}
+	if len(r.OrgIDs) > 0 && !matchesAny(r.OrgIDs, evt.OrgID, equals) {
+		return false
+	}
+	if len(r.EnvIDs) > 0 && !matchesAny(r.EnvIDs, evt.EnvID, equals) {
+		return false
+	}
+	if r.assetID != nil && !r.assetID.MatchString(evt.AssetID) {
+		return false
+	}
+	if len(r.windows) > 0 {
+		for _, window := range r.windows {
+			if window.contains(evt.Time) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// contains returns whether the time is in the window. A window spanning midnight belongs to the
+// day it starts on. The time of day is the wall clock one, so that it is right on DST changes.
+func (w compiledWindow) contains(t time.Time) bool {
+	local := t.In(w.location)
+	timeOfDay := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	day := local.Weekday()
+
+	var inWindow bool
+	switch {
+	case w.start < w.end:
+		inWindow = timeOfDay >= w.start && timeOfDay < w.end
+	case w.start > w.end:
+		inWindow = timeOfDay >= w.start
+		if !inWindow && timeOfDay < w.end {
+			inWindow = true
+			day = (day + 6) % 7
+		}
+	default:
+		// A window starting and ending at the same time spans the whole day
+		inWindow = true
+	}
+
+	return inWindow && (w.days == nil || w.days[day])
+}
+
+func matchesAny(patterns []string, value string, match func(string, string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equals(pattern string, value string) bool {
+	return pattern == value
+}
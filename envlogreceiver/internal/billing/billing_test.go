@@ -0,0 +1,127 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	// A Wednesday
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	event := Event{Interface: "eth0", Direction: DirectionTotal, OrgID: "org", EnvID: "env", AssetID: "app-prod-1", Time: noon}
+
+	t.Run("Default billability without rules", func(t *testing.T) {
+		classifier, err := New(nil)
+		assert.NoError(t, err)
+
+		assert.Equal(t, Result{Rule: DefaultRule, Billable: true}, classifier.Classify(event, true))
+		assert.Equal(t, Result{Rule: DefaultRule, Billable: false}, classifier.Classify(event, false))
+	})
+
+	t.Run("First matching rule", func(t *testing.T) {
+		classifier, err := New([]Rule{
+			{Name: "internal", Billable: false, Interfaces: []string{"lo", "flannel*", "cni*"}},
+			{Name: "production", Billable: true, AssetID: "-prod-"},
+			{Name: "everything", Billable: false},
+		})
+		assert.NoError(t, err)
+
+		loopback := event
+		loopback.Interface = "lo"
+		overlay := event
+		overlay.Interface = "flannel.1"
+		staging := event
+		staging.AssetID = "app-staging-1"
+
+		assert.Equal(t, Result{Rule: "internal", Billable: false}, classifier.Classify(loopback, true))
+		assert.Equal(t, Result{Rule: "internal", Billable: false}, classifier.Classify(overlay, true))
+		assert.Equal(t, Result{Rule: "production", Billable: true}, classifier.Classify(event, false))
+		assert.Equal(t, Result{Rule: "everything", Billable: false}, classifier.Classify(staging, true))
+	})
+
+	t.Run("All conditions must match", func(t *testing.T) {
+		classifier, err := New([]Rule{
+			{Name: "free-tier", Billable: false, OrgIDs: []string{"org"}, EnvIDs: []string{"sandbox"}, Directions: []string{DirectionTotal}},
+		})
+		assert.NoError(t, err)
+
+		sandbox := event
+		sandbox.EnvID = "sandbox"
+
+		assert.Equal(t, Result{Rule: "free-tier", Billable: false}, classifier.Classify(sandbox, true))
+		assert.Equal(t, Result{Rule: DefaultRule, Billable: true}, classifier.Classify(event, true))
+	})
+
+	t.Run("Time windows", func(t *testing.T) {
+		classifier, err := New([]Rule{
+			{Name: "business-hours", Billable: true, TimeWindows: []TimeWindow{{Start: "09:00", End: "17:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}}}},
+			{Name: "overnight", Billable: false, TimeWindows: []TimeWindow{{Start: "22:00", End: "06:00", Days: []string{"wed"}, Timezone: "America/New_York"}}},
+		})
+		assert.NoError(t, err)
+
+		at := func(t time.Time) Event {
+			evt := event
+			evt.Time = t
+			return evt
+		}
+
+		assert.Equal(t, "business-hours", classifier.Classify(at(noon), false).Rule)
+		assert.Equal(t, DefaultRule, classifier.Classify(at(noon.Add(5*time.Hour)), false).Rule, "17:00 is excluded")
+		assert.Equal(t, DefaultRule, classifier.Classify(at(noon.AddDate(0, 0, 3)), false).Rule, "Saturday is not in the window")
+
+		// 23:00 on Wednesday and 01:00 on Thursday in New York, in the window started on Wednesday
+		assert.Equal(t, "overnight", classifier.Classify(at(time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)), true).Rule)
+		assert.Equal(t, "overnight", classifier.Classify(at(time.Date(2024, 5, 2, 5, 0, 0, 0, time.UTC)), true).Rule)
+		// 01:00 on Wednesday in New York, in the window started on Tuesday
+		assert.Equal(t, DefaultRule, classifier.Classify(at(time.Date(2024, 5, 1, 5, 0, 0, 0, time.UTC)), true).Rule)
+	})
+
+	t.Run("Time windows on DST changes", func(t *testing.T) {
+		classifier, err := New([]Rule{
+			{Name: "maintenance", Billable: false, TimeWindows: []TimeWindow{{Start: "03:00", End: "04:00", Timezone: "America/New_York"}}},
+		})
+		assert.NoError(t, err)
+
+		at := func(t time.Time) Event {
+			evt := event
+			evt.Time = t
+			return evt
+		}
+
+		// 03:30 in New York on the days the clocks go forward and back
+		assert.Equal(t, "maintenance", classifier.Classify(at(time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC)), true).Rule)
+		assert.Equal(t, "maintenance", classifier.Classify(at(time.Date(2024, 11, 3, 8, 30, 0, 0, time.UTC)), true).Rule)
+		// 02:30 in New York on the day the clocks go back
+		assert.Equal(t, DefaultRule, classifier.Classify(at(time.Date(2024, 11, 3, 7, 30, 0, 0, time.UTC)), true).Rule)
+	})
+}
+
+func TestNew(t *testing.T) {
+	invalid := map[string]Rule{
+		"No name":           {Billable: true},
+		"Default name":      {Name: DefaultRule},
+		"Invalid interface": {Name: "rule", Interfaces: []string{"eth["}},
+		"Invalid direction": {Name: "rule", Directions: []string{"sideways"}},
+		"Ingress direction": {Name: "rule", Directions: []string{"ingress"}},
+		"Invalid asset ID":  {Name: "rule", AssetID: "("},
+		"Invalid start":     {Name: "rule", TimeWindows: []TimeWindow{{Start: "9am", End: "17:00"}}},
+		"Invalid day":       {Name: "rule", TimeWindows: []TimeWindow{{Start: "09:00", End: "17:00", Days: []string{"someday"}}}},
+		"Invalid timezone":  {Name: "rule", TimeWindows: []TimeWindow{{Start: "09:00", End: "17:00", Timezone: "Mars/Olympus"}}},
+	}
+
+	for name, rule := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := New([]Rule{rule})
+
+			assert.Error(t, err, "Expected an error, but err was nil")
+		})
+	}
+
+	t.Run("Duplicated name", func(t *testing.T) {
+		_, err := New([]Rule{{Name: "rule"}, {Name: "rule"}})
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
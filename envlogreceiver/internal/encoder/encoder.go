@@ -0,0 +1,453 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Constants for valid encoding values
+const (
+	JSON        = "json"
+	CSV         = "csv"
+	Protobuf    = "protobuf"
+	CloudEvents = "cloudevents"
+)
+
+// schemaIDKey is the key of the schema ID in the metadata of the entries
+const schemaIDKey = "schema_id"
+
+// Encoder encodes the entries of a sampler, serialized to JSON, into messages.
+type Encoder interface {
+	// Encode encodes the entry into messages, which are written one after the other.
+	Encode(entry []byte) ([][]byte, error)
+	// Frame frames the message to be written to a file along with other messages.
+	Frame(message []byte) []byte
+	// Header returns the header written at the start of each file, nil if none.
+	Header() []byte
+	// Binary reports whether the messages are binary rather than text.
+	Binary() bool
+}
+
+// New creates the encoder of the given encoding. JSON is used when the encoding is empty.
+func New(encoding string) (Encoder, error) {
+	switch encoding {
+	case "", JSON:
+		return jsonEncoder{}, nil
+	case CSV:
+		return csvEncoder{}, nil
+	case Protobuf:
+		return protobufEncoder{}, nil
+	case CloudEvents:
+		return cloudEventsEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %s. Possible Values: [%s, %s, %s, %s]", encoding, JSON, CSV, Protobuf, CloudEvents)
+	}
+}
+
+// kind is the type of the value of an event field
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindUint
+	kindBool
+	kindDouble
+)
+
+// field is an event field with its own CSV column and protobuf field.
+type field struct {
+	name   string
+	number protowire.Number
+	kind   kind
+}
+
+// eventFields are the event fields with their own CSV column and protobuf field. The other fields
+// are kept in the extra column or field, as a JSON object.
+var eventFields = []field{
+	{"id", 1, kindString},
+	{"timestamp", 2, kindInt},
+	{"root_org_id", 3, kindString},
+	{"org_id", 4, kindString},
+	{"env_id", 5, kindString},
+	{"asset_id", 6, kindString},
+	{"worker_id", 7, kindString},
+	{"usage_bytes", 8, kindUint},
+	{"billable", 9, kindBool},
+	{"host_name", 10, kindString},
+	{"sequence", 11, kindUint},
+	{"interval_start_ms", 12, kindInt},
+	{"interval_end_ms", 13, kindInt},
+	{"mode", 14, kindString},
+	{"start_timestamp", 15, kindInt},
+	{"rate_per_second", 16, kindDouble},
+	{"baseline", 17, kindBool},
+	{"heartbeat", 18, kindBool},
+	{"billing_rule", 19, kindString},
+	{"interface", 20, kindString},
+	{"direction", 21, kindString},
+}
+
+// extraNumber is the protobuf field of the event fields without their own field
+const extraNumber protowire.Number = 100
+
+// entry is an entry of a sampler with its events decoded field by field.
+type entry struct {
+	Format   string                       `json:"format"`
+	Time     int64                        `json:"time"`
+	Events   []map[string]json.RawMessage `json:"events"`
+	Metadata map[string]string            `json:"metadata"`
+}
+
+func decode(data []byte) (entry, error) {
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return entry{}, fmt.Errorf("decode entry: %w", err)
+	}
+	return e, nil
+}
+
+// extra returns the fields of the event without their own column or field as a JSON object, empty
+// if there are none.
+func extra(event map[string]json.RawMessage) (string, error) {
+	fields := map[string]json.RawMessage{}
+	for name, value := range event {
+		fields[name] = value
+	}
+	for _, f := range eventFields {
+		delete(fields, f.name)
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// jsonEncoder writes the entries as they are, one per line.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(entry []byte) ([][]byte, error) {
+	return [][]byte{entry}, nil
+}
+
+func (jsonEncoder) Frame(message []byte) []byte {
+	return append(message, '\n')
+}
+
+func (jsonEncoder) Header() []byte {
+	return nil
+}
+
+func (jsonEncoder) Binary() bool {
+	return false
+}
+
+// csvEncoder writes a row per event, with the schema ID, format and time of its entry, a column
+// per event field and an extra column.
+type csvEncoder struct{}
+
+// csvColumns returns the columns of the rows
+func csvColumns() []string {
+	columns := []string{schemaIDKey, "format", "time"}
+	for _, f := range eventFields {
+		columns = append(columns, f.name)
+	}
+	return append(columns, "extra")
+}
+
+func (csvEncoder) Encode(data []byte) ([][]byte, error) {
+	e, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows bytes.Buffer
+	writer := csv.NewWriter(&rows)
+	for _, event := range e.Events {
+		row := []string{e.Metadata[schemaIDKey], e.Format, strconv.FormatInt(e.Time, 10)}
+		for _, f := range eventFields {
+			row = append(row, csvValue(event[f.name]))
+		}
+
+		extraFields, err := extra(event)
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, extraFields)
+
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+
+	return [][]byte{bytes.TrimSuffix(rows.Bytes(), []byte("\n"))}, writer.Error()
+}
+
+// csvValue returns the value of a field, unquoted if it is a string and empty if it is missing.
+func csvValue(value json.RawMessage) string {
+	if len(value) == 0 || string(value) == "null" {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
+
+func (csvEncoder) Frame(message []byte) []byte {
+	if len(message) == 0 {
+		return message
+	}
+	return append(message, '\n')
+}
+
+func (csvEncoder) Header() []byte {
+	var header bytes.Buffer
+	writer := csv.NewWriter(&header)
+	_ = writer.Write(csvColumns())
+	writer.Flush()
+	return header.Bytes()
+}
+
+func (csvEncoder) Binary() bool {
+	return false
+}
+
+// protobufEncoder writes the entries as protobuf messages, prefixed by their length in files. See
+// sampler.proto for the schema of the messages.
+type protobufEncoder struct{}
+
+// Protobuf fields of the entry message
+const (
+	entryFormatNumber   protowire.Number = 1
+	entryTimeNumber     protowire.Number = 2
+	entryMetadataNumber protowire.Number = 3
+	entryEventsNumber   protowire.Number = 4
+)
+
+func (protobufEncoder) Encode(data []byte) ([][]byte, error) {
+	e, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var message []byte
+	message = appendString(message, entryFormatNumber, e.Format)
+	if e.Time != 0 {
+		message = protowire.AppendTag(message, entryTimeNumber, protowire.VarintType)
+		message = protowire.AppendVarint(message, uint64(e.Time))
+	}
+
+	keys := make([]string, 0, len(e.Metadata))
+	for key := range e.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var mapEntry []byte
+		mapEntry = appendString(mapEntry, 1, key)
+		mapEntry = appendString(mapEntry, 2, e.Metadata[key])
+		message = protowire.AppendTag(message, entryMetadataNumber, protowire.BytesType)
+		message = protowire.AppendBytes(message, mapEntry)
+	}
+
+	for _, event := range e.Events {
+		encoded, err := encodeEvent(event)
+		if err != nil {
+			return nil, err
+		}
+		message = protowire.AppendTag(message, entryEventsNumber, protowire.BytesType)
+		message = protowire.AppendBytes(message, encoded)
+	}
+
+	return [][]byte{message}, nil
+}
+
+func encodeEvent(event map[string]json.RawMessage) ([]byte, error) {
+	var message []byte
+
+	for _, f := range eventFields {
+		value, found := event[f.name]
+		if !found || string(value) == "null" {
+			continue
+		}
+
+		switch f.kind {
+		case kindString:
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", f.name, err)
+			}
+			message = appendString(message, f.number, s)
+		case kindInt:
+			var i int64
+			if err := json.Unmarshal(value, &i); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", f.name, err)
+			}
+			if i != 0 {
+				message = protowire.AppendTag(message, f.number, protowire.VarintType)
+				message = protowire.AppendVarint(message, uint64(i))
+			}
+		case kindUint:
+			var u uint64
+			if err := json.Unmarshal(value, &u); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", f.name, err)
+			}
+			if u != 0 {
+				message = protowire.AppendTag(message, f.number, protowire.VarintType)
+				message = protowire.AppendVarint(message, u)
+			}
+		case kindBool:
+			var b bool
+			if err := json.Unmarshal(value, &b); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", f.name, err)
+			}
+			if b {
+				message = protowire.AppendTag(message, f.number, protowire.VarintType)
+				message = protowire.AppendVarint(message, protowire.EncodeBool(b))
+			}
+		case kindDouble:
+			var d float64
+			if err := json.Unmarshal(value, &d); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", f.name, err)
+			}
+			// The rate is optional, so a zero rate is written as well
+			message = protowire.AppendTag(message, f.number, protowire.Fixed64Type)
+			message = protowire.AppendFixed64(message, math.Float64bits(d))
+		}
+	}
+
+	extraFields, err := extra(event)
+	if err != nil {
+		return nil, err
+	}
+	return appendString(message, extraNumber, extraFields), nil
+}
+
+// appendString appends the string field, unless it is empty.
+func appendString(message []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return message
+	}
+	message = protowire.AppendTag(message, number, protowire.BytesType)
+	return protowire.AppendString(message, value)
+}
+
+func (protobufEncoder) Frame(message []byte) []byte {
+	return protowire.AppendBytes(nil, message)
+}
+
+func (protobufEncoder) Header() []byte {
+	return nil
+}
+
+func (protobufEncoder) Binary() bool {
+	return true
+}
+
+// CloudEventsTypePrefix is the prefix of the type of the CloudEvents, followed by the schema ID of
+// the entry of the event.
+const CloudEventsTypePrefix = "com.mulesoft.metering."
+
+// TimeFields are the event fields holding the end of the time the event covers, in unix epoch
+// milliseconds, by order of preference.
+var TimeFields = []string{"interval_end_ms", "gap_end_ms", "period_end_ms", "window_end_ms", "timestamp"}
+
+// cloudEvent is a CloudEvents 1.0 event in the structured JSON format.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// cloudEventsEncoder writes each event in a CloudEvents envelope, one per line. The envelope data
+// is the event as it is serialized in the entry.
+type cloudEventsEncoder struct{}
+
+func (cloudEventsEncoder) Encode(data []byte) ([][]byte, error) {
+	e, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([][]byte, 0, len(e.Events))
+	for _, event := range e.Events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		message, err := json.Marshal(cloudEvent{
+			SpecVersion:     "1.0",
+			ID:              stringValue(event["id"]),
+			Source:          cloudEventSource(event),
+			Type:            CloudEventsTypePrefix + e.Metadata[schemaIDKey],
+			Time:            cloudEventTime(event, e.Time),
+			DataContentType: "application/json",
+			Data:            data,
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// cloudEventSource returns the source of the event, which identifies the deployment it was
+// measured for within its organization and environment.
+func cloudEventSource(event map[string]json.RawMessage) string {
+	return "/organizations/" + url.PathEscape(stringValue(event["org_id"])) +
+		"/environments/" + url.PathEscape(stringValue(event["env_id"])) +
+		"/deployments/" + url.PathEscape(stringValue(event["asset_id"]))
+}
+
+// cloudEventTime returns the end of the time the event covers as RFC 3339, or the time of its
+// entry if the event has none.
+func cloudEventTime(event map[string]json.RawMessage, entryTime int64) string {
+	for _, name := range TimeFields {
+		var ms int64
+		if err := json.Unmarshal(event[name], &ms); err == nil && ms != 0 {
+			return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return time.UnixMilli(entryTime).UTC().Format(time.RFC3339Nano)
+}
+
+// stringValue returns the value of a string field, empty if it is missing or not a string.
+func stringValue(value json.RawMessage) string {
+	var s string
+	_ = json.Unmarshal(value, &s)
+	return s
+}
+
+func (cloudEventsEncoder) Frame(message []byte) []byte {
+	return append(message, '\n')
+}
+
+func (cloudEventsEncoder) Header() []byte {
+	return nil
+}
+
+func (cloudEventsEncoder) Binary() bool {
+	return false
+}
@@ -0,0 +1,228 @@
+package encoder
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const testEntry = `{"format":"v1","time":1714564800000,"events":[` +
+	`{"id":"a1","timestamp":1714564800000,"org_id":"org","usage_bytes":1024,"billable":true,"rate_per_second":51.2,"window":{"samples":2}},` +
+	`{"id":"a2","org_id":"org, \"quoted\"","usage_bytes":0,"billable":false}` +
+	`],"metadata":{"schema_id":"network_schema_id"}}`
+
+func TestNew(t *testing.T) {
+	for _, encoding := range []string{"", JSON, CSV, Protobuf, CloudEvents} {
+		_, err := New(encoding)
+		assert.NoError(t, err, encoding)
+	}
+
+	_, err := New("xml")
+	assert.Error(t, err, "Expected an error, but err was nil")
+}
+
+func TestJSON(t *testing.T) {
+	e, _ := New(JSON)
+
+	messages, err := e.Encode([]byte(testEntry))
+
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	message := messages[0]
+	assert.Equal(t, testEntry, string(message))
+	assert.Equal(t, testEntry+"\n", string(e.Frame(message)))
+	assert.Nil(t, e.Header())
+	assert.False(t, e.Binary())
+}
+
+func TestCSV(t *testing.T) {
+	e, _ := New(CSV)
+
+	t.Run("Header", func(t *testing.T) {
+		header, err := csv.NewReader(strings.NewReader(string(e.Header()))).Read()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"schema_id", "format", "time", "id", "timestamp"}, header[:5])
+		assert.Equal(t, "extra", header[len(header)-1])
+		assert.Len(t, header, len(eventFields)+4)
+	})
+
+	t.Run("A row per event", func(t *testing.T) {
+		messages, err := e.Encode([]byte(testEntry))
+		assert.NoError(t, err)
+		assert.Len(t, messages, 1, "The rows are written at once")
+		message := messages[0]
+
+		rows, err := csv.NewReader(strings.NewReader(string(e.Header()) + string(e.Frame(message)))).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 3)
+
+		value := func(row int, column string) string {
+			for i, name := range rows[0] {
+				if name == column {
+					return rows[row][i]
+				}
+			}
+			return "missing column " + column
+		}
+
+		assert.Equal(t, "network_schema_id", value(1, "schema_id"))
+		assert.Equal(t, "1714564800000", value(1, "time"))
+		assert.Equal(t, "a1", value(1, "id"))
+		assert.Equal(t, "1024", value(1, "usage_bytes"))
+		assert.Equal(t, "true", value(1, "billable"))
+		assert.Equal(t, "51.2", value(1, "rate_per_second"))
+		assert.Equal(t, `{"window":{"samples":2}}`, value(1, "extra"))
+		assert.Equal(t, `org, "quoted"`, value(2, "org_id"))
+		assert.Equal(t, "", value(2, "timestamp"))
+		assert.Equal(t, "", value(2, "extra"))
+	})
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		_, err := e.Encode([]byte("not json"))
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
+
+func TestProtobuf(t *testing.T) {
+	e, _ := New(Protobuf)
+	assert.True(t, e.Binary())
+	assert.Nil(t, e.Header())
+
+	messages, err := e.Encode([]byte(testEntry))
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	message := messages[0]
+
+	t.Run("Length delimited", func(t *testing.T) {
+		framed := e.Frame(message)
+
+		length, n := protowire.ConsumeVarint(framed)
+		assert.Equal(t, uint64(len(message)), length)
+		assert.Equal(t, message, framed[n:])
+	})
+
+	t.Run("Entry fields", func(t *testing.T) {
+		entry := consumeFields(t, message)
+
+		assert.Equal(t, []any{"v1"}, entry[1])
+		assert.Equal(t, []any{uint64(1714564800000)}, entry[2])
+		assert.Len(t, entry[4], 2)
+
+		metadata := consumeFields(t, entry[3][0].([]byte))
+		assert.Equal(t, []any{"schema_id"}, metadata[1])
+		assert.Equal(t, []any{"network_schema_id"}, metadata[2])
+
+		first := consumeFields(t, entry[4][0].([]byte))
+		assert.Equal(t, []any{"a1"}, first[1])
+		assert.Equal(t, []any{"org"}, first[4])
+		assert.Equal(t, []any{uint64(1024)}, first[8])
+		assert.Equal(t, []any{uint64(1)}, first[9])
+		assert.Equal(t, []any{math.Float64bits(51.2)}, first[16])
+		assert.Equal(t, []any{`{"window":{"samples":2}}`}, first[100])
+
+		second := consumeFields(t, entry[4][1].([]byte))
+		assert.Equal(t, []any{`org, "quoted"`}, second[4])
+		assert.NotContains(t, second, protowire.Number(8), "Zero values are not written")
+		assert.NotContains(t, second, protowire.Number(100))
+	})
+}
+
+func TestCloudEvents(t *testing.T) {
+	e, _ := New(CloudEvents)
+	assert.False(t, e.Binary())
+	assert.Nil(t, e.Header())
+
+	messages, err := e.Encode([]byte(testEntry))
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2, "An envelope per event")
+
+	t.Run("Envelope", func(t *testing.T) {
+		var event map[string]any
+		assert.NoError(t, json.Unmarshal(messages[0], &event))
+
+		assert.Equal(t, "1.0", event["specversion"])
+		assert.Equal(t, "a1", event["id"])
+		assert.Equal(t, "/organizations/org/environments//deployments/", event["source"])
+		assert.Equal(t, CloudEventsTypePrefix+"network_schema_id", event["type"])
+		assert.Equal(t, "2024-05-01T12:00:00Z", event["time"])
+		assert.Equal(t, "application/json", event["datacontenttype"])
+		assert.Equal(t, map[string]any{
+			"id":              "a1",
+			"timestamp":       float64(1714564800000),
+			"org_id":          "org",
+			"usage_bytes":     float64(1024),
+			"billable":        true,
+			"rate_per_second": 51.2,
+			"window":          map[string]any{"samples": float64(2)},
+		}, event["data"])
+	})
+
+	t.Run("Interval end", func(t *testing.T) {
+		messages, err := e.Encode([]byte(`{"time":1714564800000,"events":[{"id":"a1","org_id":"o","env_id":"e","asset_id":"my app","interval_end_ms":1714564790000}]}`))
+		assert.NoError(t, err)
+
+		var event map[string]any
+		assert.NoError(t, json.Unmarshal(messages[0], &event))
+		assert.Equal(t, "2024-05-01T11:59:50Z", event["time"])
+		assert.Equal(t, "/organizations/o/environments/e/deployments/my%20app", event["source"])
+	})
+
+	t.Run("Entry time", func(t *testing.T) {
+		var event map[string]any
+		assert.NoError(t, json.Unmarshal(messages[1], &event))
+
+		assert.Equal(t, "2024-05-01T12:00:00Z", event["time"])
+		assert.Equal(t, string(messages[1])+"\n", string(e.Frame(messages[1])))
+	})
+}
+
+// consumeFields returns the values of the fields of the message by number. Bytes fields are
+// returned as strings when they are valid UTF-8 text, and as []byte otherwise.
+func consumeFields(t *testing.T, message []byte) map[protowire.Number][]any {
+	fields := map[protowire.Number][]any{}
+
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		assert.GreaterOrEqual(t, n, 0)
+		message = message[n:]
+
+		switch wireType {
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(message)
+			fields[number] = append(fields[number], value)
+			message = message[n:]
+		case protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(message)
+			fields[number] = append(fields[number], value)
+			message = message[n:]
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(message)
+			if isText(value) {
+				fields[number] = append(fields[number], string(value))
+			} else {
+				fields[number] = append(fields[number], value)
+			}
+			message = message[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", wireType)
+		}
+	}
+
+	return fields
+}
+
+func isText(value []byte) bool {
+	for _, b := range value {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -7,7 +7,6 @@ import (
 	"go.uber.org/zap"
 	"testing"
 
-	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/fileconsumer"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator/helper"
 	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/collector/component"
@@ -20,7 +19,7 @@ func TestNewFileInputConfig(t *testing.T) {
 	assert.NotNil(t, config, "NewFileInputConfig should not return nil")
 	assert.Equal(t, config.InputConfig.ID(), operatorType, "The operator ID should match the operatorType")
 	assert.IsType(t, helper.InputConfig{}, config.InputConfig, "InputConfig should be of type helper.InputConfig")
-	assert.IsType(t, fileconsumer.Config{}, config.Config, "Config should be of type fileconsumer.Config")
+	assert.IsType(t, ConsumerConfig{}, config.ConsumerConfig, "ConsumerConfig should be of type ConsumerConfig")
 }
 
 // TestNewFileInputConfigWithID tests the NewFileInputConfigWithID function.
@@ -32,13 +31,13 @@ func TestNewFileInputConfigWithID(t *testing.T) {
 	assert.Equal(t, config.InputConfig.ID(), operatorID, "The operator ID should match the provided operatorID")
 	assert.Equal(t, config.InputConfig.Type(), operatorType, "The operator type should match the operatorType")
 	assert.IsType(t, helper.InputConfig{}, config.InputConfig, "InputConfig should be of type helper.InputConfig")
-	assert.IsType(t, fileconsumer.Config{}, config.Config, "Config should be of type fileconsumer.Config")
+	assert.IsType(t, ConsumerConfig{}, config.ConsumerConfig, "ConsumerConfig should be of type ConsumerConfig")
 }
 
 // TestBuild tests the Build method of the InputConfig struct.
 func TestBuild(t *testing.T) {
 	config := NewFileInputConfig()
-	config.Criteria.Include = append(config.Criteria.Include, "dummy")
+	config.Path = "dummy"
 	set := createMockTelemetrySettings()
 
 	operator, err := config.Build(set)
@@ -50,7 +49,7 @@ func TestBuild(t *testing.T) {
 // TestInput tests the Input method of the InputConfig struct.
 func TestInput(t *testing.T) {
 	config := NewFileInputConfig()
-	config.Criteria.Include = append(config.Criteria.Include, "dummy")
+	config.Path = "dummy"
 	set := createMockTelemetrySettings()
 
 	_, err := config.Build(set)
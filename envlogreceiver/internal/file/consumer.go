@@ -57,20 +57,20 @@ func (c ConsumerConfig) Build(set component.TelemetrySettings, emit func(ctx con
 	criteria := matcher.Criteria{Include: []string{c.Path}}
 
 	config := fileconsumer.Config{
-		criteria,
-		c.Resolver,
-		c.PollInterval,
-		c.MaxConcurrentFiles,
-		c.MaxBatches,
-		c.StartAt,
-		c.FingerprintSize,
-		c.MaxLogSize,
-		c.Encoding,
-		c.SplitConfig,
-		c.TrimConfig,
-		c.FlushPeriod,
-		c.Header,
-		c.DeleteAfterRead,
+		Criteria:           criteria,
+		Resolver:           c.Resolver,
+		PollInterval:       c.PollInterval,
+		MaxConcurrentFiles: c.MaxConcurrentFiles,
+		MaxBatches:         c.MaxBatches,
+		StartAt:            c.StartAt,
+		FingerprintSize:    c.FingerprintSize,
+		MaxLogSize:         c.MaxLogSize,
+		Encoding:           c.Encoding,
+		SplitConfig:        c.SplitConfig,
+		TrimConfig:         c.TrimConfig,
+		FlushPeriod:        c.FlushPeriod,
+		Header:             c.Header,
+		DeleteAfterRead:    c.DeleteAfterRead,
 	}
 
 	return config.Build(set, emit)
@@ -0,0 +1,75 @@
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	procStat   = "/proc/stat"
+	procBootID = "/proc/sys/kernel/random/boot_id"
+)
+
+// BootTime returns the time the system booted, read from /proc/stat. This is also the time
+// the network interface counters started.
+func BootTime() (time.Time, error) {
+	f, err := os.Open(procStat)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	return ParseBootTime(f)
+}
+
+// ParseBootTime parses the boot time from data in the format of /proc/stat, where the boot
+// time is the "btime" line in seconds since the unix epoch.
+func ParseBootTime(data io.Reader) (time.Time, error) {
+	scanner := bufio.NewScanner(data)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parse btime: %w", err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("btime not found in stat info")
+}
+
+// BootID returns the random identifier the kernel generates on each boot, read from
+// /proc/sys/kernel/random/boot_id. It tells apart the counter readings of different boots.
+func BootID() (string, error) {
+	f, err := os.Open(procBootID)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ParseBootID(f)
+}
+
+// ParseBootID parses the boot ID from data in the format of /proc/sys/kernel/random/boot_id,
+// which holds the ID on a single line. It fails if the ID is empty.
+func ParseBootID(data io.Reader) (string, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return "", fmt.Errorf("read boot_id: %w", err)
+	}
+
+	bootID := strings.TrimSpace(string(content))
+	if bootID == "" {
+		return "", fmt.Errorf("empty boot_id")
+	}
+	return bootID, nil
+}
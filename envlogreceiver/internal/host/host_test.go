@@ -0,0 +1,48 @@
+package host
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBootTime(t *testing.T) {
+	t.Run("Boot time parsed from the stat file", func(t *testing.T) {
+		f, err := os.Open("testdata/stat.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		bootTime, err := ParseBootTime(f)
+
+		assert.NoError(t, err, "Error on parsing the boot time")
+		assert.Equal(t, time.Unix(1062191376, 0), bootTime)
+	})
+
+	t.Run("Missing boot time", func(t *testing.T) {
+		_, err := ParseBootTime(strings.NewReader("cpu  4705 356 584 3699 23 23 0 0 0 0\n"))
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
+
+func TestParseBootID(t *testing.T) {
+	t.Run("Boot ID parsed from the boot_id file", func(t *testing.T) {
+		f, err := os.Open("testdata/boot_id.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		bootID, err := ParseBootID(f)
+
+		assert.NoError(t, err, "Error on parsing the boot ID")
+		assert.Equal(t, "0f0b6c46-8d3b-4a53-a1b1-8f7a4c2d9e10", bootID)
+	})
+
+	t.Run("Empty boot ID", func(t *testing.T) {
+		_, err := ParseBootID(strings.NewReader("\n"))
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
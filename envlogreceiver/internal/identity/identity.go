@@ -0,0 +1,237 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Constants for the identity fields of the events
+const (
+	RootOrgID = "root_org_id"
+	OrgID     = "org_id"
+	EnvID     = "env_id"
+	AssetID   = "asset_id"
+	WorkerID  = "worker_id"
+	Billable  = "billable"
+)
+
+// ResourceFields are the identity fields shared by the events and the resource of the logs, so
+// that both can be joined downstream.
+var ResourceFields = []string{RootOrgID, OrgID, EnvID, AssetID, WorkerID}
+
+// Config represents the configuration of the identity fields added to the resource of the logs.
+type Config struct {
+	// Enabled adds the identity fields to the resource of the logs.
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// Constants for the environment variables the identity fields are read from by default
+const (
+	EnvOrgID              = "ORG_ID"
+	EnvEnvID              = "ENV_ID"
+	EnvDeploymentID       = "DEPLOYMENT_ID"
+	EnvRootOrgID          = "ROOT_ORG_ID"
+	EnvMuleBillingEnabled = "MULE_BILLING_ENABLED"
+	EnvPodName            = "POD_NAME"
+	EnvAppName            = "APP_NAME"
+)
+
+// defaultSources are the sources of the fields which are not mapped. The worker ID is derived
+// from the pod and app names when it is not mapped. The events are not accounted without their
+// organization, environment and asset, so these are required.
+var defaultSources = map[string]Source{
+	RootOrgID: {Env: EnvRootOrgID},
+	OrgID:     {Env: EnvOrgID, Required: true},
+	EnvID:     {Env: EnvEnvID, Required: true},
+	AssetID:   {Env: EnvDeploymentID, Required: true},
+	Billable:  {Env: EnvMuleBillingEnabled},
+}
+
+// Source is where the value of a field is read from. Exactly one of Env, Value, File or From
+// must be set.
+type Source struct {
+	// Env is the environment variable holding the value.
+	Env string `mapstructure:"env,omitempty"`
+	// Value is a static value.
+	Value string `mapstructure:"value,omitempty"`
+	// File is the file holding the value. Leading and trailing white space is trimmed.
+	File string `mapstructure:"file,omitempty"`
+	// From is another field the value is extracted from with Regex.
+	From string `mapstructure:"from,omitempty"`
+	// Regex extracts the value from the From field: the first capture group if any, or the
+	// whole match otherwise. The value is empty when it does not match.
+	Regex string `mapstructure:"regex,omitempty"`
+	// Required fails the resolution when the value is empty.
+	Required bool `mapstructure:"required,omitempty"`
+}
+
+// Resolver resolves the identity fields from their sources. Fields other than the identity
+// fields may be mapped as well, to extract identity fields from them.
+type Resolver struct {
+	sources map[string]Source
+	regexes map[string]*regexp.Regexp
+	// order is the order the fields are resolved in, so that a field is resolved after the field it is extracted from
+	order []string
+
+	getenv   func(string) string
+	readFile func(string) ([]byte, error)
+}
+
+// New creates a resolver of the given sources, using the default sources for the identity fields
+// which are not mapped. It fails if a source is invalid, refers to an unknown field or the fields
+// refer to each other in a cycle.
+func New(sources map[string]Source) (*Resolver, error) {
+	r := &Resolver{
+		sources:  map[string]Source{},
+		regexes:  map[string]*regexp.Regexp{},
+		getenv:   os.Getenv,
+		readFile: os.ReadFile,
+	}
+
+	for field, source := range defaultSources {
+		r.sources[field] = source
+	}
+	for field, source := range sources {
+		r.sources[field] = source
+	}
+
+	fields := make([]string, 0, len(r.sources))
+	for field := range r.sources {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if err := r.compile(field, r.sources[field]); err != nil {
+			return nil, err
+		}
+	}
+
+	visiting := map[string]bool{}
+	for _, field := range fields {
+		if err := r.visit(field, visiting); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Resolver) compile(field string, source Source) error {
+	kinds := 0
+	for _, set := range []bool{source.Env != "", source.Value != "", source.File != "", source.From != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("metadata field %s: exactly one of env, value, file or from must be set", field)
+	}
+
+	if source.From == "" {
+		if source.Regex != "" {
+			return fmt.Errorf("metadata field %s: regex is only supported with from", field)
+		}
+		return nil
+	}
+
+	if _, found := r.sources[source.From]; !found && source.From != WorkerID {
+		return fmt.Errorf("metadata field %s: unknown field %s", field, source.From)
+	}
+
+	regex, err := regexp.Compile(source.Regex)
+	if err != nil {
+		return fmt.Errorf("metadata field %s: %w", field, err)
+	}
+	r.regexes[field] = regex
+	return nil
+}
+
+// visit adds the field to the resolution order after the field it is extracted from.
+func (r *Resolver) visit(field string, visiting map[string]bool) error {
+	for _, resolved := range r.order {
+		if resolved == field {
+			return nil
+		}
+	}
+	if visiting[field] {
+		return fmt.Errorf("metadata field %s: fields refer to each other in a cycle", field)
+	}
+	visiting[field] = true
+
+	if source, found := r.sources[field]; found && source.From != "" {
+		if err := r.visit(source.From, visiting); err != nil {
+			return err
+		}
+	}
+
+	r.order = append(r.order, field)
+	return nil
+}
+
+// Resolve returns the values of the fields, by field name. The worker ID is derived from the pod
+// and app names when it is not mapped. The values are returned even if required fields are empty
+// or files could not be read, along with the error.
+func (r *Resolver) Resolve() (map[string]string, error) {
+	values := map[string]string{}
+	var errs []error
+	var missing []string
+
+	for _, field := range r.order {
+		source, found := r.sources[field]
+		if !found {
+			// Only the worker ID can be referred to without a source
+			values[field] = r.legacyWorkerID()
+			continue
+		}
+
+		switch {
+		case source.Env != "":
+			values[field] = r.getenv(source.Env)
+		case source.Value != "":
+			values[field] = source.Value
+		case source.File != "":
+			content, err := r.readFile(source.File)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("metadata field %s: %w", field, err))
+			}
+			values[field] = strings.TrimSpace(string(content))
+		case source.From != "":
+			values[field] = extract(r.regexes[field], values[source.From])
+		}
+
+		if source.Required && values[field] == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	if _, found := values[WorkerID]; !found {
+		values[WorkerID] = r.legacyWorkerID()
+	}
+
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("required metadata fields are empty: %s", strings.Join(missing, ", ")))
+	}
+	return values, errors.Join(errs...)
+}
+
+// legacyWorkerID derives the worker ID from the pod name, without the app name prefix.
+func (r *Resolver) legacyWorkerID() string {
+	return "worker-" + strings.ReplaceAll(r.getenv(EnvPodName), r.getenv(EnvAppName)+"-", "")
+}
+
+// extract returns the first capture group of the regex in the value if any, or the whole match otherwise.
+func extract(regex *regexp.Regexp, value string) string {
+	match := regex.FindStringSubmatch(value)
+	if match == nil {
+		return ""
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
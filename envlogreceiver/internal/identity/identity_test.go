@@ -0,0 +1,174 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	t.Run("Default sources", func(t *testing.T) {
+		t.Setenv(EnvOrgID, "org")
+		t.Setenv(EnvEnvID, "env")
+		t.Setenv(EnvDeploymentID, "deployment")
+		t.Setenv(EnvRootOrgID, "root")
+		t.Setenv(EnvMuleBillingEnabled, "true")
+		t.Setenv(EnvPodName, "app-7d9f-x2")
+		t.Setenv(EnvAppName, "app")
+
+		resolver, err := New(nil)
+		assert.NoError(t, err)
+
+		values, err := resolver.Resolve()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "org", values[OrgID])
+		assert.Equal(t, "env", values[EnvID])
+		assert.Equal(t, "deployment", values[AssetID])
+		assert.Equal(t, "root", values[RootOrgID])
+		assert.Equal(t, "true", values[Billable])
+		assert.Equal(t, "worker-7d9f-x2", values[WorkerID])
+	})
+
+	t.Run("Mapped sources", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "org")
+		assert.NoError(t, os.WriteFile(file, []byte("org-from-file\n"), 0600))
+		t.Setenv("HOSTNAME_FOR_TEST", "mule-worker-3")
+
+		resolver, err := New(map[string]Source{
+			OrgID:      {File: file},
+			EnvID:      {Value: "production"},
+			"pod_name": {Env: "HOSTNAME_FOR_TEST"},
+			WorkerID:   {From: "pod_name", Regex: `-(\d+)$`},
+			AssetID:    {From: "pod_name", Regex: `^[a-z]+`},
+		})
+		assert.NoError(t, err)
+
+		values, err := resolver.Resolve()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "org-from-file", values[OrgID])
+		assert.Equal(t, "production", values[EnvID])
+		assert.Equal(t, "3", values[WorkerID])
+		assert.Equal(t, "mule", values[AssetID])
+	})
+
+	t.Run("Regex not matching", func(t *testing.T) {
+		t.Setenv(EnvOrgID, "org")
+		t.Setenv(EnvEnvID, "env")
+		t.Setenv(EnvDeploymentID, "deployment")
+
+		resolver, err := New(map[string]Source{
+			"pod_name": {Value: "mule"},
+			WorkerID:   {From: "pod_name", Regex: `\d+`},
+		})
+		assert.NoError(t, err)
+
+		values, err := resolver.Resolve()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "", values[WorkerID])
+	})
+
+	t.Run("Required fields empty", func(t *testing.T) {
+		t.Setenv(EnvOrgID, "")
+
+		resolver, err := New(map[string]Source{
+			OrgID: {Env: EnvOrgID, Required: true},
+			EnvID: {Value: "production", Required: true},
+		})
+		assert.NoError(t, err)
+
+		values, err := resolver.Resolve()
+
+		assert.ErrorContains(t, err, OrgID)
+		assert.NotContains(t, err.Error(), EnvID)
+		assert.Equal(t, "production", values[EnvID])
+	})
+
+	t.Run("Required by default", func(t *testing.T) {
+		t.Setenv(EnvOrgID, "")
+		t.Setenv(EnvEnvID, "env")
+		t.Setenv(EnvDeploymentID, "deployment")
+
+		resolver, err := New(nil)
+		assert.NoError(t, err)
+
+		_, err = resolver.Resolve()
+
+		assert.ErrorContains(t, err, OrgID)
+		assert.NotContains(t, err.Error(), EnvID)
+		assert.NotContains(t, err.Error(), AssetID)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		resolver, err := New(map[string]Source{
+			OrgID: {File: filepath.Join(t.TempDir(), "missing")},
+		})
+		assert.NoError(t, err)
+
+		_, err = resolver.Resolve()
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("No source", func(t *testing.T) {
+		_, err := New(map[string]Source{OrgID: {Required: true}})
+
+		assert.Error(t, err, "A field without a source should fail")
+	})
+
+	t.Run("Several sources", func(t *testing.T) {
+		_, err := New(map[string]Source{OrgID: {Env: "ORG", Value: "org"}})
+
+		assert.Error(t, err, "A field with several sources should fail")
+	})
+
+	t.Run("Regex without from", func(t *testing.T) {
+		_, err := New(map[string]Source{OrgID: {Env: "ORG", Regex: ".*"}})
+
+		assert.Error(t, err, "A regex without from should fail")
+	})
+
+	t.Run("Invalid regex", func(t *testing.T) {
+		_, err := New(map[string]Source{WorkerID: {From: OrgID, Regex: "("}})
+
+		assert.Error(t, err, "An invalid regex should fail")
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		_, err := New(map[string]Source{WorkerID: {From: "pod_name"}})
+
+		assert.Error(t, err, "Extracting from an unknown field should fail")
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		_, err := New(map[string]Source{
+			OrgID: {From: EnvID},
+			EnvID: {From: OrgID},
+		})
+
+		assert.Error(t, err, "Fields referring to each other should fail")
+	})
+
+	t.Run("Extracting from the unmapped worker ID", func(t *testing.T) {
+		t.Setenv(EnvOrgID, "org")
+		t.Setenv(EnvEnvID, "env")
+		t.Setenv(EnvDeploymentID, "deployment")
+		t.Setenv(EnvPodName, "app-42")
+		t.Setenv(EnvAppName, "app")
+
+		resolver, err := New(map[string]Source{"worker_number": {From: WorkerID, Regex: `\d+`}})
+		assert.NoError(t, err)
+
+		values, err := resolver.Resolve()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "42", values["worker_number"])
+		assert.Equal(t, "worker-42", values[WorkerID])
+	})
+}
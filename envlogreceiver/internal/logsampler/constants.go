@@ -11,21 +11,66 @@ const (
 	OutputPipelineEmitter = "pipeline_emitter"
 )
 
-// Constants for the logs
+// Constants for valid mode values
 const (
-	LastCountKey    = "LAST_COUNT"
-	Format          = "v1"
-	SchemaID        = "schema_id"
-	NetworkSchemaId = "network_schema_id"
+	ModeDelta      = "delta"
+	ModeCumulative = "cumulative"
+	ModeGauge      = "gauge"
+	ModeRate       = "rate"
+)
+
+// Constants for valid baseline values
+const (
+	BaselineSkip                  = "skip"
+	BaselineEmitFull              = "emit_full"
+	BaselineEmitSinceProcessStart = "emit_since_process_start"
+)
+
+// Constants for valid id mode values
+const (
+	IDModeDeterministic = "deterministic"
+	IDModeRandom        = "random"
 )
 
-// Constants for environment variables
+// Constants for valid record format values
 const (
-	OrgID              = "ORG_ID"
-	EnvID              = "ENV_ID"
-	DeploymentID       = "DEPLOYMENT_ID"
-	RootOrgID          = "ROOT_ORG_ID"
-	MuleBillingEnabled = "MULE_BILLING_ENABLED"
-	PodName            = "POD_NAME"
-	AppName            = "APP_NAME"
+	RecordFormatString = "string"
+	RecordFormatMap    = "map"
+	RecordFormatEvent  = "event"
+)
+
+// Constants for valid threshold type values
+const (
+	ThresholdDelta      = "delta"
+	ThresholdRate       = "rate"
+	ThresholdDailyTotal = "daily_total"
+)
+
+// Constants for the logs
+const (
+	LastCountKey    = "LAST_COUNT"
+	CounterStartKey = "COUNTER_START"
+	SequenceKey     = "SEQUENCE"
+	// LastSampleTimeKey holds the time of the last sample in unix epoch milliseconds
+	LastSampleTimeKey = "LAST_SAMPLE_TIME"
+	// IntervalEndKey holds the end of the last emitted interval in unix epoch milliseconds
+	IntervalEndKey = "INTERVAL_END"
+	// IdleSinceKey holds the start of the span of suppressed zero usage intervals in unix epoch milliseconds
+	IdleSinceKey = "IDLE_SINCE"
+	// RollupKey holds the running totals of the open rollup periods
+	RollupKey = "ROLLUP"
+	// AlertsKey holds the names of the thresholds which raised an alert and were not re-armed yet
+	AlertsKey = "ALERTS"
+	// BatchKey holds the usage events waiting to be packed into an entry
+	BatchKey = "BATCH"
+	// BootIDKey holds the boot ID of the system the last count was read on
+	BootIDKey = "BOOT_ID"
+	// PendingIntervalKey holds the interval built but not confirmed as written yet
+	PendingIntervalKey    = "PENDING_INTERVAL"
+	SchemaID              = "schema_id"
+	NetworkSchemaId       = "network_schema_id"
+	NetworkV2SchemaId     = "network_v2_schema_id"
+	NetworkGapSchemaId    = "network_gap_schema_id"
+	NetworkRollupSchemaId = "network_rollup_schema_id"
+	NetworkAlertSchemaId  = "network_alert_schema_id"
 )
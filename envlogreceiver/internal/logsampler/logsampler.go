@@ -1,9 +1,19 @@
 package logsampler
 
 import (
+	"strings"
 	"time"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/billing"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/encoder"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/render"
 )
 
+// DefaultHeartbeatInterval is the maximum span of suppressed zero usage intervals when no
+// heartbeat interval is configured.
+const DefaultHeartbeatInterval = time.Hour
+
 // Config represents the configuration for log samplers.
 type Config struct {
 	LogSamplers []LogSampler `mapstructure:"log_samplers"`
@@ -25,6 +35,134 @@ type LogSampler struct {
 	Output       string        `mapstructure:"output"`
 	URI          string        `mapstructure:"uri"`
 	PollInterval time.Duration `mapstructure:"poll_interval,omitempty"`
+	// Mode is the value semantics of the emitted measurement. Defaults to delta.
+	Mode string `mapstructure:"mode,omitempty"`
+	// SampleInterval is the interval for taking intermediate readings which are aggregated
+	// into a window summary on each emitted event. Disabled when zero.
+	SampleInterval time.Duration `mapstructure:"sample_interval,omitempty"`
+	// EmitInterval is the interval for emitting events. Defaults to the poll interval.
+	EmitInterval time.Duration `mapstructure:"emit_interval,omitempty"`
+	// AlignToInterval aligns the emissions to wall-clock boundaries of the emit interval.
+	AlignToInterval bool `mapstructure:"align_to_interval,omitempty"`
+	// Baseline is the policy for the first sample, when there is no previous count. Defaults to emit_full.
+	Baseline string `mapstructure:"baseline,omitempty"`
+	// IDMode is how the event IDs are generated. Defaults to deterministic.
+	IDMode string `mapstructure:"id_mode,omitempty"`
+	// GapThreshold is the elapsed time since the last sample above which a gap event is emitted.
+	// Defaults to twice the emit interval. Gaps are not detected when it is negative.
+	GapThreshold time.Duration `mapstructure:"gap_threshold,omitempty"`
+	// SuppressZero suppresses the events of zero usage intervals, which are coalesced into heartbeat events.
+	SuppressZero bool `mapstructure:"suppress_zero,omitempty"`
+	// HeartbeatInterval is the maximum span of suppressed zero usage intervals covered by a heartbeat
+	// event. Defaults to DefaultHeartbeatInterval.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval,omitempty"`
+	// Rollup keeps running usage totals per day and month.
+	Rollup RollupConfig `mapstructure:"rollup,omitempty"`
+	// Thresholds raise alerts when the usage crosses them.
+	Thresholds []ThresholdConfig `mapstructure:"thresholds,omitempty"`
+	// MaxEventsPerEntry is the maximum number of usage events packed into one entry. The events
+	// are written one per entry when it is not greater than one.
+	MaxEventsPerEntry int `mapstructure:"max_events_per_entry,omitempty"`
+	// MaxBatchDelay is the maximum time the usage events are held before the entry packing them
+	// is written. Unbounded when zero.
+	MaxBatchDelay time.Duration `mapstructure:"max_batch_delay,omitempty"`
+	// Audit adds the raw counter readings the usage is computed from to the events.
+	Audit bool `mapstructure:"audit,omitempty"`
+	// Anomaly flags suspicious events.
+	Anomaly AnomalyConfig `mapstructure:"anomaly,omitempty"`
+	// Outbox holds the entries of the pipeline emitter until the pipeline accepted them.
+	Outbox OutboxConfig `mapstructure:"outbox,omitempty"`
+	// Metadata maps the identity fields of the events, and the fields they are extracted from, to
+	// their sources. The identity fields which are not mapped are read from the default env vars.
+	Metadata map[string]identity.Source `mapstructure:"metadata,omitempty"`
+	// BillingRules decide the billability of each event with the first rule it matches. The events
+	// no rule matches are billable as set by the billable field of the metadata.
+	BillingRules []billing.Rule `mapstructure:"billing_rules,omitempty"`
+	// SchemaVersion is the version of the schema the entries are written with. SchemaV1 when empty.
+	SchemaVersion string `mapstructure:"schema_version,omitempty"`
+	// Template is the Go text/template rendering the usage entries into JSON. The entries are
+	// serialized as they are when empty.
+	Template string `mapstructure:"template,omitempty"`
+	// Encoding is the encoding the entries are written with: json, csv or protobuf. JSON when empty.
+	Encoding string `mapstructure:"encoding,omitempty"`
+	// RecordFormat is the format of the log records of the pipeline emitter: the serialized entry as
+	// a string, the entry as a map, or a map per event. Defaults to string.
+	RecordFormat string `mapstructure:"record_format,omitempty"`
+}
+
+// OutboxConfig represents the configuration of the durable outbox of a sampler.
+type OutboxConfig struct {
+	// Enabled enables the outbox. Only supported by the pipeline emitter output.
+	Enabled bool `mapstructure:"enabled"`
+	// MaxEntries is the maximum number of entries held. The oldest entries are dropped when full.
+	MaxEntries int `mapstructure:"max_entries,omitempty"`
+	// DeliveryTimeout is the time after which an entry neither accepted nor rejected by the pipeline,
+	// e.g. because it was dropped downstream, is delivered again.
+	DeliveryTimeout time.Duration `mapstructure:"delivery_timeout,omitempty"`
+}
+
+// RollupConfig represents the configuration of the daily and monthly usage rollups of a sampler.
+type RollupConfig struct {
+	// Enabled enables the rollups.
+	Enabled bool `mapstructure:"enabled"`
+	// Timezone is the IANA time zone of the day and month boundaries. Defaults to UTC.
+	Timezone string `mapstructure:"timezone,omitempty"`
+}
+
+// ThresholdConfig represents a usage threshold which raises an alert when crossed.
+type ThresholdConfig struct {
+	// Name identifies the threshold in the alerts.
+	Name string `mapstructure:"name"`
+	// Type is the measurement compared with the threshold: delta, rate or daily_total.
+	Type string `mapstructure:"type"`
+	// Value is the threshold, in bytes for delta and daily_total, and in bytes per second for rate.
+	Value float64 `mapstructure:"value"`
+	// Hysteresis is the fraction of the threshold the measurement must drop below it before
+	// another alert is raised. Zero re-arms the threshold as soon as the measurement is not above it.
+	Hysteresis float64 `mapstructure:"hysteresis,omitempty"`
+}
+
+// AnomalyConfig represents the configuration of the anomaly flagging of a sampler.
+type AnomalyConfig struct {
+	// Enabled enables the anomaly flagging.
+	Enabled bool `mapstructure:"enabled"`
+	// SpikeFactor is the factor of the rolling median of the recent usages above which a usage is a spike.
+	SpikeFactor float64 `mapstructure:"spike_factor,omitempty"`
+	// MedianWindow is the number of recent usages the rolling median is computed on.
+	MedianWindow int `mapstructure:"median_window,omitempty"`
+	// MaxSampleDuration is the duration above which a sample is slow.
+	MaxSampleDuration time.Duration `mapstructure:"max_sample_duration,omitempty"`
+}
+
+// EffectiveEmitInterval returns the interval for emitting events: the emit interval if set,
+// or the poll interval otherwise.
+func (s LogSampler) EffectiveEmitInterval() time.Duration {
+	if s.EmitInterval > 0 {
+		return s.EmitInterval
+	}
+	return s.PollInterval
+}
+
+// EffectiveGapThreshold returns the elapsed time since the last sample above which a gap event
+// is emitted: the gap threshold if set, or twice the emit interval otherwise. It is zero when
+// the gap threshold is negative, which disables the gap detection.
+func (s LogSampler) EffectiveGapThreshold() time.Duration {
+	if s.GapThreshold < 0 {
+		return 0
+	}
+	if s.GapThreshold > 0 {
+		return s.GapThreshold
+	}
+	return 2 * s.EffectiveEmitInterval()
+}
+
+// EffectiveHeartbeatInterval returns the maximum span of suppressed zero usage intervals: the
+// heartbeat interval if set, or DefaultHeartbeatInterval otherwise.
+func (s LogSampler) EffectiveHeartbeatInterval() time.Duration {
+	if s.HeartbeatInterval > 0 {
+		return s.HeartbeatInterval
+	}
+	return DefaultHeartbeatInterval
 }
 
 // Validate validates the configuration.
@@ -45,6 +183,116 @@ func (cfg *Config) Validate() error {
 		default:
 			return &LogSamplerError{"Incorrect output in sampler. Possible Values: [" + OutputFileLogger + ", " + OutputPipelineEmitter + "]"}
 		}
+		switch logSampler.Mode {
+		case "", ModeDelta, ModeCumulative, ModeGauge, ModeRate:
+			break
+		default:
+			return &LogSamplerError{"Incorrect mode in sampler. Possible Values: [" + ModeDelta + ", " + ModeCumulative + ", " + ModeGauge + ", " + ModeRate + "]"}
+		}
+		switch logSampler.Baseline {
+		case "", BaselineSkip, BaselineEmitFull, BaselineEmitSinceProcessStart:
+			break
+		default:
+			return &LogSamplerError{"Incorrect baseline in sampler. Possible Values: [" + BaselineSkip + ", " + BaselineEmitFull + ", " + BaselineEmitSinceProcessStart + "]"}
+		}
+		switch logSampler.IDMode {
+		case "", IDModeDeterministic, IDModeRandom:
+			break
+		default:
+			return &LogSamplerError{"Incorrect id_mode in sampler. Possible Values: [" + IDModeDeterministic + ", " + IDModeRandom + "]"}
+		}
+		if logSampler.EffectiveEmitInterval() <= 0 {
+			return &LogSamplerError{"Incorrect emit interval in sampler. The poll_interval or emit_interval must be positive"}
+		}
+		if logSampler.HeartbeatInterval != 0 && !logSampler.SuppressZero {
+			return &LogSamplerError{"Incorrect heartbeat_interval in sampler. It is only supported with suppress_zero"}
+		}
+		if logSampler.HeartbeatInterval < 0 || (logSampler.HeartbeatInterval > 0 && logSampler.HeartbeatInterval < logSampler.EffectiveEmitInterval()) {
+			return &LogSamplerError{"Incorrect heartbeat_interval in sampler. It must not be lower than the emit interval"}
+		}
+		if _, err := time.LoadLocation(logSampler.Rollup.Timezone); err != nil {
+			return &LogSamplerError{"Incorrect rollup timezone in sampler: " + err.Error()}
+		}
+		names := map[string]bool{}
+		for _, threshold := range logSampler.Thresholds {
+			if threshold.Name == "" || names[threshold.Name] {
+				return &LogSamplerError{"Incorrect threshold in sampler. Each threshold must have a unique name"}
+			}
+			names[threshold.Name] = true
+
+			switch threshold.Type {
+			case ThresholdDelta, ThresholdRate:
+				break
+			case ThresholdDailyTotal:
+				if !logSampler.Rollup.Enabled {
+					return &LogSamplerError{"Incorrect threshold " + threshold.Name + " in sampler. The " + ThresholdDailyTotal + " type requires the rollup to be enabled"}
+				}
+			default:
+				return &LogSamplerError{"Incorrect threshold type in sampler. Possible Values: [" + ThresholdDelta + ", " + ThresholdRate + ", " + ThresholdDailyTotal + "]"}
+			}
+			if threshold.Value <= 0 {
+				return &LogSamplerError{"Incorrect threshold " + threshold.Name + " in sampler. The value must be positive"}
+			}
+			if threshold.Hysteresis < 0 || threshold.Hysteresis >= 1 {
+				return &LogSamplerError{"Incorrect threshold " + threshold.Name + " in sampler. The hysteresis must be a fraction between 0 and 1"}
+			}
+		}
+		if logSampler.Anomaly.SpikeFactor < 0 || (logSampler.Anomaly.SpikeFactor > 0 && logSampler.Anomaly.SpikeFactor <= 1) {
+			return &LogSamplerError{"Incorrect anomaly spike_factor in sampler. It must be greater than 1"}
+		}
+		if logSampler.Anomaly.MedianWindow < 0 || logSampler.Anomaly.MaxSampleDuration < 0 {
+			return &LogSamplerError{"Incorrect anomaly in sampler. The median_window and max_sample_duration must not be negative"}
+		}
+		if logSampler.MaxEventsPerEntry < 0 {
+			return &LogSamplerError{"Incorrect max_events_per_entry in sampler. It must not be negative"}
+		}
+		if logSampler.MaxBatchDelay < 0 || (logSampler.MaxBatchDelay > 0 && logSampler.MaxEventsPerEntry <= 1) {
+			return &LogSamplerError{"Incorrect max_batch_delay in sampler. It must be positive and is only supported with max_events_per_entry greater than 1"}
+		}
+		if logSampler.Outbox.Enabled && logSampler.Output != OutputPipelineEmitter {
+			return &LogSamplerError{"Incorrect outbox in sampler. It is only supported by the " + OutputPipelineEmitter + " output"}
+		}
+		if logSampler.Outbox.MaxEntries < 0 || logSampler.Outbox.DeliveryTimeout < 0 {
+			return &LogSamplerError{"Incorrect outbox in sampler. The max_entries and delivery_timeout must not be negative"}
+		}
+		if _, err := identity.New(logSampler.Metadata); err != nil {
+			return &LogSamplerError{"Incorrect metadata in sampler: " + err.Error()}
+		}
+		if logSampler.SchemaVersion != "" {
+			if _, found := LookupSchema(logSampler.Metric, EntryUsage, logSampler.SchemaVersion); !found {
+				return &LogSamplerError{"Incorrect schema_version in sampler. Possible Values: [" + strings.Join(SchemaVersions(logSampler.Metric), ", ") + "]"}
+			}
+		}
+		if _, err := render.New(logSampler.Template); err != nil {
+			return &LogSamplerError{"Incorrect template in sampler: " + err.Error()}
+		}
+		if _, err := encoder.New(logSampler.Encoding); err != nil {
+			return &LogSamplerError{"Incorrect encoding in sampler: " + err.Error()}
+		}
+		if logSampler.Template != "" && logSampler.Encoding != "" && logSampler.Encoding != encoder.JSON {
+			return &LogSamplerError{"Incorrect template in sampler. It is only supported by the " + encoder.JSON + " encoding"}
+		}
+		switch logSampler.RecordFormat {
+		case "", RecordFormatString:
+		case RecordFormatMap, RecordFormatEvent:
+			if logSampler.Output != OutputPipelineEmitter {
+				return &LogSamplerError{"Incorrect record_format in sampler. It is only supported by the " + OutputPipelineEmitter + " output"}
+			}
+			if logSampler.Encoding != "" && logSampler.Encoding != encoder.JSON {
+				return &LogSamplerError{"Incorrect record_format in sampler. It is only supported by the " + encoder.JSON + " encoding"}
+			}
+		default:
+			return &LogSamplerError{"Incorrect record_format in sampler. Possible Values: [" + RecordFormatString + ", " + RecordFormatMap + ", " + RecordFormatEvent + "]"}
+		}
+		if _, err := billing.New(logSampler.BillingRules); err != nil {
+			return &LogSamplerError{"Incorrect billing_rules in sampler: " + err.Error()}
+		}
+		if logSampler.SampleInterval > 0 && logSampler.SampleInterval >= logSampler.EffectiveEmitInterval() {
+			return &LogSamplerError{"Incorrect sample_interval in sampler. It must be lower than the emit interval"}
+		}
+		if logSampler.GapThreshold > 0 && logSampler.GapThreshold <= logSampler.EffectiveEmitInterval() {
+			return &LogSamplerError{"Incorrect gap_threshold in sampler. It must be greater than the emit interval"}
+		}
 	}
 	return nil
 }
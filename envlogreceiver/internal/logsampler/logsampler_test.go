@@ -2,8 +2,13 @@ package logsampler
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/billing"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/encoder"
+	"github.com/fsgonz/mule-runtime-master-env-log-receiver/envlogreceiver/internal/identity"
 )
 
 func TestConfig_Validate(t *testing.T) {
@@ -17,9 +22,10 @@ func TestConfig_Validate(t *testing.T) {
 		cfg := &Config{
 			LogSamplers: []LogSampler{
 				{
-					Metric: MetricNetstats,
-					Output: OutputFileLogger,
-					URI:    "example.log",
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
 				},
 			},
 		}
@@ -31,14 +37,16 @@ func TestConfig_Validate(t *testing.T) {
 		cfg := &Config{
 			LogSamplers: []LogSampler{
 				{
-					Metric: MetricNetstats,
-					Output: OutputFileLogger,
-					URI:    "example.log",
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
 				},
 				{
-					Metric: MetricNetstats,
-					Output: OutputPipelineEmitter,
-					URI:    "example.log",
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					URI:          "example.log",
 				},
 			},
 		}
@@ -50,9 +58,10 @@ func TestConfig_Validate(t *testing.T) {
 		cfg := &Config{
 			LogSamplers: []LogSampler{
 				{
-					Metric: "invalid_metric",
-					Output: OutputFileLogger,
-					URI:    "example.log",
+					Metric:       "invalid_metric",
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
 				},
 			},
 		}
@@ -64,13 +73,414 @@ func TestConfig_Validate(t *testing.T) {
 		cfg := &Config{
 			LogSamplers: []LogSampler{
 				{
-					Metric: MetricNetstats,
-					Output: "invalid_output",
-					URI:    "example.log",
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       "invalid_output",
+					URI:          "example.log",
 				},
 			},
 		}
 		err := cfg.Validate()
 		assert.Error(t, err, "Invalid output should fail validation")
 	})
+	t.Run("Valid mode", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Mode:         ModeRate,
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.NoError(t, err, "Valid mode should pass validation")
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Mode:         "invalid_mode",
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.Error(t, err, "Invalid mode should fail validation")
+	})
+	t.Run("Emit interval not set", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric: MetricNetstats,
+					Output: OutputPipelineEmitter,
+				},
+			},
+		}
+		assert.Error(t, cfg.Validate(), "A sampler without poll_interval nor emit_interval should fail validation")
+
+		cfg.LogSamplers[0].PollInterval = -time.Second
+		assert.Error(t, cfg.Validate(), "A negative poll_interval should fail validation")
+
+		cfg.LogSamplers[0].EmitInterval = 15 * time.Second
+		assert.NoError(t, cfg.Validate())
+	})
+	t.Run("Sample interval lower than emit interval", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:         MetricNetstats,
+					Output:         OutputPipelineEmitter,
+					PollInterval:   time.Minute,
+					SampleInterval: time.Second,
+					EmitInterval:   20 * time.Second,
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.NoError(t, err, "Sample interval lower than emit interval should pass validation")
+		assert.Equal(t, 20*time.Second, cfg.LogSamplers[0].EffectiveEmitInterval())
+	})
+
+	t.Run("Sample interval not lower than emit interval", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:         MetricNetstats,
+					Output:         OutputPipelineEmitter,
+					PollInterval:   time.Second,
+					SampleInterval: time.Second,
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.Error(t, err, "Sample interval not lower than emit interval should fail validation")
+	})
+	t.Run("Invalid baseline", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Baseline:     "invalid_baseline",
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.Error(t, err, "Invalid baseline should fail validation")
+	})
+	t.Run("Invalid id mode", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					IDMode:       "invalid_id_mode",
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.Error(t, err, "Invalid id mode should fail validation")
+	})
+	t.Run("Outbox with file logger", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
+					Outbox:       OutboxConfig{Enabled: true},
+				},
+			},
+		}
+		err := cfg.Validate()
+		assert.Error(t, err, "Outbox with file logger should fail validation")
+	})
+	t.Run("Outbox delivery timeout", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Outbox:       OutboxConfig{Enabled: true, DeliveryTimeout: 10 * time.Minute},
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Outbox.DeliveryTimeout = -time.Minute
+		assert.Error(t, cfg.Validate(), "Negative delivery timeout should fail validation")
+	})
+	t.Run("Gap threshold", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					Output:       OutputPipelineEmitter,
+					PollInterval: 20 * time.Second,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, 40*time.Second, cfg.LogSamplers[0].EffectiveGapThreshold(), "The default gap threshold should be twice the emit interval")
+
+		cfg.LogSamplers[0].GapThreshold = 5 * time.Minute
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, 5*time.Minute, cfg.LogSamplers[0].EffectiveGapThreshold())
+
+		cfg.LogSamplers[0].GapThreshold = 10 * time.Second
+		assert.Error(t, cfg.Validate(), "Gap threshold not greater than the emit interval should fail validation")
+
+		cfg.LogSamplers[0].GapThreshold = -1
+		assert.NoError(t, cfg.Validate())
+		assert.Zero(t, cfg.LogSamplers[0].EffectiveGapThreshold(), "A negative gap threshold should disable the gap detection")
+	})
+	t.Run("Heartbeat interval", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:            MetricNetstats,
+					Output:            OutputPipelineEmitter,
+					PollInterval:      20 * time.Second,
+					HeartbeatInterval: 15 * time.Minute,
+				},
+			},
+		}
+		assert.Error(t, cfg.Validate(), "Heartbeat interval without suppress zero should fail validation")
+
+		cfg.LogSamplers[0].SuppressZero = true
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].HeartbeatInterval = 10 * time.Second
+		assert.Error(t, cfg.Validate(), "Heartbeat interval lower than the emit interval should fail validation")
+
+		cfg.LogSamplers[0].HeartbeatInterval = 0
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, DefaultHeartbeatInterval, cfg.LogSamplers[0].EffectiveHeartbeatInterval())
+	})
+	t.Run("Rollup timezone", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Rollup:       RollupConfig{Enabled: true, Timezone: "UTC"},
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Rollup.Timezone = "Invalid/Timezone"
+		assert.Error(t, cfg.Validate(), "Invalid rollup timezone should fail validation")
+	})
+	t.Run("Thresholds", func(t *testing.T) {
+		validThresholds := []ThresholdConfig{
+			{Name: "delta", Type: ThresholdDelta, Value: 1024},
+			{Name: "rate", Type: ThresholdRate, Value: 100, Hysteresis: 0.1},
+			{Name: "daily", Type: ThresholdDailyTotal, Value: 1 << 30},
+		}
+		newConfig := func(thresholds ...ThresholdConfig) *Config {
+			return &Config{
+				LogSamplers: []LogSampler{
+					{
+						Metric:       MetricNetstats,
+						PollInterval: time.Minute,
+						Output:       OutputPipelineEmitter,
+						Rollup:       RollupConfig{Enabled: true},
+						Thresholds:   thresholds,
+					},
+				},
+			}
+		}
+
+		assert.NoError(t, newConfig(validThresholds...).Validate())
+		assert.Error(t, newConfig(ThresholdConfig{Type: ThresholdDelta, Value: 1}).Validate(), "Threshold without name should fail validation")
+		assert.Error(t, newConfig(validThresholds[0], validThresholds[0]).Validate(), "Duplicated threshold names should fail validation")
+		assert.Error(t, newConfig(ThresholdConfig{Name: "t", Type: "invalid_type", Value: 1}).Validate(), "Invalid threshold type should fail validation")
+		assert.Error(t, newConfig(ThresholdConfig{Name: "t", Type: ThresholdDelta}).Validate(), "Threshold without value should fail validation")
+		assert.Error(t, newConfig(ThresholdConfig{Name: "t", Type: ThresholdDelta, Value: 1, Hysteresis: 1}).Validate(), "Invalid hysteresis should fail validation")
+
+		withoutRollup := newConfig(validThresholds[2])
+		withoutRollup.LogSamplers[0].Rollup.Enabled = false
+		assert.Error(t, withoutRollup.Validate(), "Daily total threshold without rollup should fail validation")
+	})
+	t.Run("Anomaly", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					Anomaly:      AnomalyConfig{Enabled: true, SpikeFactor: 5, MedianWindow: 20, MaxSampleDuration: time.Second},
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Anomaly.SpikeFactor = 0.5
+		assert.Error(t, cfg.Validate(), "Spike factor not greater than 1 should fail validation")
+
+		cfg.LogSamplers[0].Anomaly.SpikeFactor = 0
+		cfg.LogSamplers[0].Anomaly.MedianWindow = -1
+		assert.Error(t, cfg.Validate(), "Negative median window should fail validation")
+	})
+	t.Run("Batching", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:            MetricNetstats,
+					PollInterval:      time.Minute,
+					Output:            OutputFileLogger,
+					URI:               "example.log",
+					MaxEventsPerEntry: 10,
+					MaxBatchDelay:     5 * time.Minute,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].MaxEventsPerEntry = 1
+		assert.Error(t, cfg.Validate(), "Max batch delay without batching should fail validation")
+
+		cfg.LogSamplers[0].MaxEventsPerEntry = -1
+		assert.Error(t, cfg.Validate(), "Negative max events per entry should fail validation")
+	})
+	t.Run("Metadata", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
+					Metadata: map[string]identity.Source{
+						identity.OrgID:    {Env: "ORG_ID", Required: true},
+						"pod_name":        {Env: "POD_NAME"},
+						identity.WorkerID: {From: "pod_name", Regex: `-(\d+)$`},
+					},
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Metadata[identity.EnvID] = identity.Source{Env: "ENV_ID", Value: "production"}
+		assert.Error(t, cfg.Validate(), "Several sources for a field should fail validation")
+
+		cfg.LogSamplers[0].Metadata[identity.EnvID] = identity.Source{From: "unknown"}
+		assert.Error(t, cfg.Validate(), "Extracting from an unknown field should fail validation")
+	})
+	t.Run("Billing rules", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
+					BillingRules: []billing.Rule{
+						{Name: "internal", Billable: false, Interfaces: []string{"lo", "flannel*"}},
+					},
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].BillingRules = append(cfg.LogSamplers[0].BillingRules, billing.Rule{Name: "internal"})
+		assert.Error(t, cfg.Validate(), "Duplicated rule names should fail validation")
+
+		cfg.LogSamplers[0].BillingRules = []billing.Rule{{Name: "rule", Directions: []string{"sideways"}}}
+		assert.Error(t, cfg.Validate(), "Incorrect direction should fail validation")
+	})
+	t.Run("Schema version", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:        MetricNetstats,
+					PollInterval:  time.Minute,
+					Output:        OutputFileLogger,
+					URI:           "example.log",
+					SchemaVersion: SchemaV2,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].SchemaVersion = "v3"
+		assert.Error(t, cfg.Validate(), "Unknown schema version should fail validation")
+	})
+	t.Run("Template", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
+					Template:     `{"bytes": {{ (index .Events 0).UsageBytes }}}`,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Template = `{{ range .Events }}`
+		assert.Error(t, cfg.Validate(), "Unterminated template should fail validation")
+	})
+	t.Run("Encoding", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputFileLogger,
+					URI:          "example.log",
+					Encoding:     encoder.CSV,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].Encoding = "xml"
+		assert.Error(t, cfg.Validate(), "Unknown encoding should fail validation")
+
+		cfg.LogSamplers[0].Encoding = encoder.Protobuf
+		cfg.LogSamplers[0].Template = `{"bytes": {{ (index .Events 0).UsageBytes }}}`
+		assert.Error(t, cfg.Validate(), "Template with a binary encoding should fail validation")
+
+		cfg.LogSamplers[0].Encoding = encoder.JSON
+		assert.NoError(t, cfg.Validate())
+	})
+	t.Run("Record format", func(t *testing.T) {
+		cfg := &Config{
+			LogSamplers: []LogSampler{
+				{
+					Metric:       MetricNetstats,
+					PollInterval: time.Minute,
+					Output:       OutputPipelineEmitter,
+					RecordFormat: RecordFormatEvent,
+				},
+			},
+		}
+		assert.NoError(t, cfg.Validate())
+
+		cfg.LogSamplers[0].RecordFormat = "list"
+		assert.Error(t, cfg.Validate(), "Unknown record format should fail validation")
+
+		cfg.LogSamplers[0].RecordFormat = RecordFormatMap
+		cfg.LogSamplers[0].Encoding = encoder.CSV
+		assert.Error(t, cfg.Validate(), "Map records with another encoding than json should fail validation")
+
+		cfg.LogSamplers[0].Encoding = ""
+		cfg.LogSamplers[0].Output = OutputFileLogger
+		cfg.LogSamplers[0].URI = "example.log"
+		assert.Error(t, cfg.Validate(), "Map records with the file logger output should fail validation")
+	})
 }
@@ -0,0 +1,85 @@
+package logsampler
+
+// Constants for valid schema version values, from the oldest
+const (
+	SchemaV1 = "v1"
+	SchemaV2 = "v2"
+)
+
+// Constants for the kinds of entries written by a sampler
+const (
+	EntryUsage  = "usage"
+	EntryGap    = "gap"
+	EntryRollup = "rollup"
+	EntryAlert  = "alert"
+)
+
+// Schema identifies the shape of an entry.
+type Schema struct {
+	// ID is the schema ID set in the metadata of the entry
+	ID string
+	// Version is the format of the entry
+	Version string
+}
+
+// schemaVersions are the schema versions, from the oldest
+var schemaVersions = []string{SchemaV1, SchemaV2}
+
+// schemaRegistry holds the schemas of each kind of entry by metric, from the oldest version. A
+// kind of entry whose shape did not change in a version is not registered again for it.
+var schemaRegistry = map[string]map[string][]Schema{
+	MetricNetstats: {
+		EntryUsage: {
+			{ID: NetworkSchemaId, Version: SchemaV1},
+			{ID: NetworkV2SchemaId, Version: SchemaV2},
+		},
+		EntryGap:    {{ID: NetworkGapSchemaId, Version: SchemaV1}},
+		EntryRollup: {{ID: NetworkRollupSchemaId, Version: SchemaV1}},
+		EntryAlert:  {{ID: NetworkAlertSchemaId, Version: SchemaV1}},
+	},
+}
+
+// LookupSchema returns the schema of the kind of entries of the metric for the given version,
+// which is the latest schema registered up to that version, and whether one is registered.
+// SchemaV1 is used when the version is empty.
+func LookupSchema(metric string, kind string, version string) (Schema, bool) {
+	if version == "" {
+		version = SchemaV1
+	}
+
+	rank := schemaRank(version)
+	if rank < 0 {
+		return Schema{}, false
+	}
+
+	var found *Schema
+	for i, schema := range schemaRegistry[metric][kind] {
+		if schemaRank(schema.Version) <= rank {
+			found = &schemaRegistry[metric][kind][i]
+		}
+	}
+	if found == nil {
+		return Schema{}, false
+	}
+	return *found, true
+}
+
+// SchemaVersions returns the schema versions the entries of the metric can be written with.
+func SchemaVersions(metric string) []string {
+	var versions []string
+	for _, version := range schemaVersions {
+		if _, found := LookupSchema(metric, EntryUsage, version); found {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+func schemaRank(version string) int {
+	for i, v := range schemaVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,43 @@
+package logsampler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupSchema(t *testing.T) {
+	t.Run("Schema of the version", func(t *testing.T) {
+		schema, found := LookupSchema(MetricNetstats, EntryUsage, SchemaV2)
+
+		assert.True(t, found)
+		assert.Equal(t, Schema{ID: NetworkV2SchemaId, Version: SchemaV2}, schema)
+	})
+
+	t.Run("First version by default", func(t *testing.T) {
+		schema, found := LookupSchema(MetricNetstats, EntryUsage, "")
+
+		assert.True(t, found)
+		assert.Equal(t, Schema{ID: NetworkSchemaId, Version: SchemaV1}, schema)
+	})
+
+	t.Run("Latest schema up to the version", func(t *testing.T) {
+		schema, found := LookupSchema(MetricNetstats, EntryGap, SchemaV2)
+
+		assert.True(t, found)
+		assert.Equal(t, Schema{ID: NetworkGapSchemaId, Version: SchemaV1}, schema)
+	})
+
+	t.Run("Unknown version or metric", func(t *testing.T) {
+		_, found := LookupSchema(MetricNetstats, EntryUsage, "v0")
+		assert.False(t, found)
+
+		_, found = LookupSchema("unknown", EntryUsage, SchemaV1)
+		assert.False(t, found)
+	})
+}
+
+func TestSchemaVersions(t *testing.T) {
+	assert.Equal(t, []string{SchemaV1, SchemaV2}, SchemaVersions(MetricNetstats))
+	assert.Empty(t, SchemaVersions("unknown"))
+}
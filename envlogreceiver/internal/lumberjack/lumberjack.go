@@ -1,5 +1,5 @@
 // Taken from https://github.com/natefinch/lumberjack
-// Changes made to use KB instead of MB for MaxSize
+// Changes made to use KB instead of MB for MaxSize, and to write a header at the start of each file
 
 // Package lumberjack provides a rolling logger.
 //
@@ -110,6 +110,10 @@ type Logger struct {
 	// using gzip. The default is not to perform compression.
 	Compress bool `json:"compress" yaml:"compress"`
 
+	// Header is written at the start of each new log file, such as the header
+	// row of a CSV file. No header is written if empty.
+	Header []byte `json:"header" yaml:"header"`
+
 	size int64
 	file *os.File
 	mu   sync.Mutex
@@ -241,6 +245,19 @@ func (l *Logger) openNew() error {
 	}
 	l.file = f
 	l.size = 0
+	return l.writeHeader()
+}
+
+// writeHeader writes the header at the start of the current file, which must be empty.
+func (l *Logger) writeHeader() error {
+	if len(l.Header) == 0 {
+		return nil
+	}
+	n, err := l.file.Write(l.Header)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("can't write header: %s", err)
+	}
 	return nil
 }
 
@@ -288,6 +305,9 @@ func (l *Logger) openExistingOrNew(writeLen int) error {
 	}
 	l.file = file
 	l.size = info.Size()
+	if l.size == 0 {
+		return l.writeHeader()
+	}
 	return nil
 }
 
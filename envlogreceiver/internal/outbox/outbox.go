@@ -0,0 +1,275 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/stanza/operator"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// indexKey is the key the IDs of the records are persisted under
+	indexKey = "SAMPLER_OUTBOX_INDEX"
+	// recordKeyPrefix is the prefix of the keys each record is persisted under, followed by its ID
+	recordKeyPrefix = "SAMPLER_OUTBOX_"
+
+	// DefaultMaxEntries is the number of entries kept when no maximum is configured
+	DefaultMaxEntries = 1000
+	// DefaultDeliveryTimeout is the time after which an unsettled delivery is considered lost when
+	// no timeout is configured
+	DefaultDeliveryTimeout = 5 * time.Minute
+)
+
+// Record is an entry held in the outbox until it is acknowledged.
+type Record struct {
+	// ID identifies the record. IDs increase in the order the records were appended.
+	ID uint64 `json:"id"`
+	// Entry is the serialized entry
+	Entry []byte `json:"entry"`
+}
+
+// index is the persisted list of the records held, which are each persisted under their own key.
+type index struct {
+	NextID uint64   `json:"next_id"`
+	IDs    []uint64 `json:"ids"`
+}
+
+// Outbox is a durable, bounded and ordered queue of entries which are removed only once they
+// are acknowledged. Each entry is persisted under its own key, along with an index of the held
+// entries, so that they survive restarts. When the outbox is full, the oldest entries are dropped.
+//
+// Records are either in flight, when they were delivered and are waiting for an acknowledgement,
+// or undelivered. Records loaded from the storage on start are undelivered, as well as records
+// which delivery failed, or was neither acknowledged nor rejected within the delivery timeout, as
+// when the entry was dropped downstream.
+type Outbox struct {
+	persister       operator.Persister
+	maxEntries      int
+	deliveryTimeout time.Duration
+	now             func() time.Time
+
+	mux     sync.Mutex
+	nextID  uint64
+	records []Record
+	// inFlight holds the time each record in flight was delivered at
+	inFlight map[uint64]time.Time
+
+	dropped metric.Int64Counter
+}
+
+// New creates an outbox loading the records persisted by a previous run. maxEntries is the maximum
+// number of records held, DefaultMaxEntries when it is not positive, and deliveryTimeout the time
+// after which an unsettled delivery is considered lost, DefaultDeliveryTimeout when it is not positive.
+func New(ctx context.Context, persister operator.Persister, maxEntries int, deliveryTimeout time.Duration, meter metric.Meter) (*Outbox, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	if deliveryTimeout <= 0 {
+		deliveryTimeout = DefaultDeliveryTimeout
+	}
+
+	o := &Outbox{
+		persister:       persister,
+		maxEntries:      maxEntries,
+		deliveryTimeout: deliveryTimeout,
+		now:             time.Now,
+		inFlight:        map[uint64]time.Time{},
+	}
+
+	var err error
+	o.dropped, err = meter.Int64Counter(
+		"sampler_outbox_dropped_entries",
+		metric.WithDescription("Number of sampler entries dropped from the outbox because it was full"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.Int64ObservableGauge(
+		"sampler_outbox_entries",
+		metric.WithDescription("Number of sampler entries held in the outbox"),
+		metric.WithInt64Callback(func(_ context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(o.Len()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.load(ctx); err != nil {
+		return nil, fmt.Errorf("load outbox: %w", err)
+	}
+	return o, nil
+}
+
+// load loads the records of the persisted index. Records whose key is missing, because the process
+// died while they were removed, are skipped.
+func (o *Outbox) load(ctx context.Context) error {
+	data, _ := o.persister.Get(ctx, indexKey)
+	if data == nil {
+		return nil
+	}
+
+	var idx index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return err
+	}
+
+	o.nextID = idx.NextID
+	for _, id := range idx.IDs {
+		if entry, _ := o.persister.Get(ctx, recordKey(id)); entry != nil {
+			o.records = append(o.records, Record{ID: id, Entry: entry})
+		}
+	}
+	return nil
+}
+
+// Append persists the entry as a new in flight record. If the outbox is full, the oldest records
+// are dropped. The outbox is left as it was when the record can not be persisted, so that the
+// entry can be appended again.
+func (o *Outbox) Append(ctx context.Context, entry []byte) (Record, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	record := Record{ID: o.nextID + 1, Entry: entry}
+	if err := o.persister.Set(ctx, recordKey(record.ID), entry); err != nil {
+		return Record{}, fmt.Errorf("persist outbox record: %w", err)
+	}
+
+	records := append(append(make([]Record, 0, len(o.records)+1), o.records...), record)
+	var dropped []Record
+	if excess := len(records) - o.maxEntries; excess > 0 {
+		dropped = records[:excess]
+		records = records[excess:]
+	}
+
+	if err := o.writeIndex(ctx, record.ID, records); err != nil {
+		_ = o.persister.Delete(ctx, recordKey(record.ID))
+		return Record{}, err
+	}
+
+	o.nextID = record.ID
+	o.records = records
+	o.inFlight[record.ID] = o.now()
+	for _, record := range dropped {
+		delete(o.inFlight, record.ID)
+	}
+	if len(dropped) > 0 {
+		o.dropped.Add(ctx, int64(len(dropped)))
+	}
+
+	// The record is appended once it is in the index, so it is not reported as failed if the keys
+	// of the dropped records can not be removed. They are not in the index, so they are not loaded.
+	_ = o.removeRecords(ctx, dropped)
+	return record, nil
+}
+
+// Undelivered returns the records which are not in flight, or whose delivery timed out, in the
+// order they were appended, and marks them as in flight.
+func (o *Outbox) Undelivered() []Record {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	now := o.now()
+	var records []Record
+	for _, record := range o.records {
+		if deliveredAt, found := o.inFlight[record.ID]; !found || now.Sub(deliveredAt) >= o.deliveryTimeout {
+			o.inFlight[record.ID] = now
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Ack removes the records with the given IDs, which were accepted downstream.
+func (o *Outbox) Ack(ctx context.Context, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	acked := map[uint64]bool{}
+	for _, id := range ids {
+		acked[id] = true
+		delete(o.inFlight, id)
+	}
+
+	var removed []Record
+	records := o.records[:0]
+	for _, record := range o.records {
+		if acked[record.ID] {
+			removed = append(removed, record)
+		} else {
+			records = append(records, record)
+		}
+	}
+	o.records = records
+
+	return o.persist(ctx, removed)
+}
+
+// Nack marks the records with the given IDs, which were not accepted downstream, as undelivered.
+func (o *Outbox) Nack(ids []uint64) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	for _, id := range ids {
+		delete(o.inFlight, id)
+	}
+}
+
+// Len returns the number of records held in the outbox.
+func (o *Outbox) Len() int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	return len(o.records)
+}
+
+// persist persists the index, then removes the keys of the removed records. A removed record whose
+// key is left behind, if the process dies in between, is not in the index and is not loaded.
+func (o *Outbox) persist(ctx context.Context, removed []Record) error {
+	if err := o.writeIndex(ctx, o.nextID, o.records); err != nil {
+		return err
+	}
+	return o.removeRecords(ctx, removed)
+}
+
+// writeIndex persists the index of the given records.
+func (o *Outbox) writeIndex(ctx context.Context, nextID uint64, records []Record) error {
+	idx := index{NextID: nextID, IDs: make([]uint64, 0, len(records))}
+	for _, record := range records {
+		idx.IDs = append(idx.IDs, record.ID)
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := o.persister.Set(ctx, indexKey, data); err != nil {
+		return fmt.Errorf("persist outbox: %w", err)
+	}
+	return nil
+}
+
+// removeRecords removes the keys of the removed records.
+func (o *Outbox) removeRecords(ctx context.Context, removed []Record) error {
+	for _, record := range removed {
+		if err := o.persister.Delete(ctx, recordKey(record.ID)); err != nil {
+			return fmt.Errorf("remove outbox record: %w", err)
+		}
+	}
+	return nil
+}
+
+func recordKey(id uint64) string {
+	return recordKeyPrefix + strconv.FormatUint(id, 10)
+}
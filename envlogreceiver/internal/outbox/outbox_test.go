@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	meter := noop.NewMeterProvider().Meter("test")
+
+	t.Run("Acknowledged records are removed", func(t *testing.T) {
+		box, err := New(ctx, &testPersister{data: map[string][]byte{}}, 10, 0, meter)
+		assert.NoError(t, err)
+
+		first, err := box.Append(ctx, []byte("first"))
+		assert.NoError(t, err)
+		second, err := box.Append(ctx, []byte("second"))
+		assert.NoError(t, err)
+
+		assert.NoError(t, box.Ack(ctx, []uint64{first.ID}))
+
+		assert.Equal(t, 1, box.Len())
+		assert.Less(t, first.ID, second.ID)
+	})
+
+	t.Run("Not acknowledged records are delivered again in order", func(t *testing.T) {
+		box, err := New(ctx, &testPersister{data: map[string][]byte{}}, 10, 0, meter)
+		assert.NoError(t, err)
+
+		first, _ := box.Append(ctx, []byte("first"))
+		second, _ := box.Append(ctx, []byte("second"))
+
+		assert.Empty(t, box.Undelivered(), "In flight records should not be delivered again")
+
+		box.Nack([]uint64{second.ID, first.ID})
+
+		assert.Equal(t, []Record{first, second}, box.Undelivered())
+		assert.Empty(t, box.Undelivered())
+	})
+
+	t.Run("Oldest records are dropped when full", func(t *testing.T) {
+		box, err := New(ctx, &testPersister{data: map[string][]byte{}}, 2, 0, meter)
+		assert.NoError(t, err)
+
+		_, _ = box.Append(ctx, []byte("first"))
+		second, _ := box.Append(ctx, []byte("second"))
+		third, _ := box.Append(ctx, []byte("third"))
+		box.Nack([]uint64{second.ID, third.ID})
+
+		assert.Equal(t, []Record{second, third}, box.Undelivered())
+	})
+
+	t.Run("Records survive restarts and are delivered in order", func(t *testing.T) {
+		persister := &testPersister{data: map[string][]byte{}}
+		box, err := New(ctx, persister, 10, 0, meter)
+		assert.NoError(t, err)
+
+		first, _ := box.Append(ctx, []byte("first"))
+		second, _ := box.Append(ctx, []byte("second"))
+		third, _ := box.Append(ctx, []byte("third"))
+		assert.NoError(t, box.Ack(ctx, []uint64{second.ID}))
+
+		restarted, err := New(ctx, persister, 10, 0, meter)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []Record{first, third}, restarted.Undelivered())
+
+		fourth, _ := restarted.Append(ctx, []byte("fourth"))
+		assert.Greater(t, fourth.ID, third.ID)
+	})
+
+	t.Run("Each record is persisted under its own key", func(t *testing.T) {
+		persister := &testPersister{data: map[string][]byte{}}
+		box, err := New(ctx, persister, 2, 0, meter)
+		assert.NoError(t, err)
+
+		first, _ := box.Append(ctx, []byte("first"))
+		second, _ := box.Append(ctx, []byte("second"))
+		assert.Equal(t, []byte("first"), persister.data[recordKey(first.ID)])
+		assert.Equal(t, []byte("second"), persister.data[recordKey(second.ID)])
+
+		third, _ := box.Append(ctx, []byte("third"))
+		assert.NotContains(t, persister.data, recordKey(first.ID), "The dropped record should be removed")
+
+		assert.NoError(t, box.Ack(ctx, []uint64{second.ID}))
+		assert.NotContains(t, persister.data, recordKey(second.ID))
+		assert.Equal(t, []byte("third"), persister.data[recordKey(third.ID)])
+		assert.JSONEq(t, `{"next_id":3,"ids":[3]}`, string(persister.data[indexKey]))
+	})
+
+	t.Run("Records not persisted are not appended", func(t *testing.T) {
+		persister := &testPersister{data: map[string][]byte{}, failIndex: true}
+		box, err := New(ctx, persister, 10, 0, meter)
+		assert.NoError(t, err)
+
+		_, err = box.Append(ctx, []byte("first"))
+		assert.Error(t, err)
+		assert.Equal(t, 0, box.Len())
+		assert.Empty(t, box.Undelivered())
+		assert.Empty(t, persister.data, "The record key should be removed")
+
+		persister.failIndex = false
+		first, err := box.Append(ctx, []byte("first"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, box.Len(), "The retried record should be held once")
+		assert.JSONEq(t, `{"next_id":1,"ids":[1]}`, string(persister.data[indexKey]))
+
+		box.Nack([]uint64{first.ID})
+		assert.Equal(t, []Record{first}, box.Undelivered())
+	})
+
+	t.Run("Unsettled records are delivered again after the delivery timeout", func(t *testing.T) {
+		box, err := New(ctx, &testPersister{data: map[string][]byte{}}, 10, time.Minute, meter)
+		assert.NoError(t, err)
+		clock := time.UnixMilli(0)
+		box.now = func() time.Time { return clock }
+
+		first, _ := box.Append(ctx, []byte("first"))
+		clock = clock.Add(30 * time.Second)
+		second, _ := box.Append(ctx, []byte("second"))
+		assert.Empty(t, box.Undelivered())
+
+		clock = clock.Add(30 * time.Second)
+		assert.Equal(t, []Record{first}, box.Undelivered())
+		assert.Empty(t, box.Undelivered(), "The record delivered again should be in flight")
+
+		clock = clock.Add(30 * time.Second)
+		assert.Equal(t, []Record{second}, box.Undelivered())
+	})
+}
+
+// testPersister is an in memory implementation of operator.Persister, which fails to persist the
+// index when failIndex is set
+type testPersister struct {
+	data      map[string][]byte
+	failIndex bool
+}
+
+func (p *testPersister) Get(_ context.Context, key string) ([]byte, error) {
+	return p.data[key], nil
+}
+
+func (p *testPersister) Set(_ context.Context, key string, value []byte) error {
+	if p.failIndex && key == indexKey {
+		return errors.New("storage unavailable")
+	}
+	p.data[key] = value
+	return nil
+}
+
+func (p *testPersister) Delete(_ context.Context, key string) error {
+	delete(p.data, key)
+	return nil
+}
@@ -0,0 +1,202 @@
+package podinfo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDirectory is the directory the downward API volume is mounted on when none is configured
+const DefaultDirectory = "/etc/podinfo"
+
+// DefaultRefreshInterval is the interval the files are checked for changes at when none is configured
+const DefaultRefreshInterval = 30 * time.Second
+
+// Constants for the files of the downward API volume
+const (
+	labelsFile      = "labels"
+	annotationsFile = "annotations"
+	namespaceFile   = "namespace"
+	uidFile         = "uid"
+)
+
+// Constants for the attributes, following the OTel semantic conventions
+const (
+	NamespaceAttribute        = "k8s.namespace.name"
+	PodUIDAttribute           = "k8s.pod.uid"
+	LabelAttributePrefix      = "k8s.pod.label."
+	AnnotationAttributePrefix = "k8s.pod.annotation."
+)
+
+// Config represents the configuration of the pod metadata read from a downward API volume.
+type Config struct {
+	// Enabled enables reading the pod metadata.
+	Enabled bool `mapstructure:"enabled"`
+	// Directory is the directory the downward API volume is mounted on, holding the labels,
+	// annotations, namespace and uid files. Missing files are ignored.
+	Directory string `mapstructure:"directory,omitempty"`
+	// Labels are the keys of the pod labels added as attributes.
+	Labels []string `mapstructure:"labels,omitempty"`
+	// Annotations are the keys of the pod annotations added as attributes.
+	Annotations []string `mapstructure:"annotations,omitempty"`
+	// RefreshInterval is the interval the files are checked for changes at. Defaults to DefaultRefreshInterval.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval,omitempty"`
+}
+
+// fileVersion identifies the content of a file, which changes when the file is updated.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reader reads the attributes of the pod from a downward API volume. The files are checked for
+// changes once per refresh interval, and read again when they changed, because the labels and
+// annotations can be updated in place.
+type Reader struct {
+	directory       string
+	labels          []string
+	annotations     []string
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mux        sync.Mutex
+	checkedAt  time.Time
+	versions   map[string]fileVersion
+	attributes map[string]string
+}
+
+// New creates a reader of the pod attributes.
+func New(cfg Config) *Reader {
+	directory := cfg.Directory
+	if directory == "" {
+		directory = DefaultDirectory
+	}
+
+	refreshInterval := cfg.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+
+	return &Reader{
+		directory:       directory,
+		labels:          cfg.Labels,
+		annotations:     cfg.Annotations,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+		attributes:      map[string]string{},
+	}
+}
+
+// Attributes returns the attributes of the pod. Once the refresh interval elapsed since the last
+// check, the files are checked and read again if any of them changed. A file which can not be
+// read is ignored.
+func (r *Reader) Attributes() map[string]string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	now := r.now()
+	if r.versions != nil && now.Sub(r.checkedAt) < r.refreshInterval {
+		return maps.Clone(r.attributes)
+	}
+	r.checkedAt = now
+
+	versions := r.stat()
+	if r.versions == nil || !maps.Equal(versions, r.versions) {
+		r.versions = versions
+		r.attributes = r.read()
+	}
+
+	return maps.Clone(r.attributes)
+}
+
+// stat returns the versions of the existing files.
+func (r *Reader) stat() map[string]fileVersion {
+	versions := map[string]fileVersion{}
+	for _, name := range []string{labelsFile, annotationsFile, namespaceFile, uidFile} {
+		// The files are symbolic links which are swapped on updates, so the links are followed
+		if info, err := os.Stat(filepath.Join(r.directory, name)); err == nil {
+			versions[name] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return versions
+}
+
+func (r *Reader) read() map[string]string {
+	attributes := map[string]string{}
+
+	if namespace, err := os.ReadFile(filepath.Join(r.directory, namespaceFile)); err == nil {
+		if value := strings.TrimSpace(string(namespace)); value != "" {
+			attributes[NamespaceAttribute] = value
+		}
+	}
+	if uid, err := os.ReadFile(filepath.Join(r.directory, uidFile)); err == nil {
+		if value := strings.TrimSpace(string(uid)); value != "" {
+			attributes[PodUIDAttribute] = value
+		}
+	}
+
+	r.readSelected(labelsFile, r.labels, LabelAttributePrefix, attributes)
+	r.readSelected(annotationsFile, r.annotations, AnnotationAttributePrefix, attributes)
+
+	return attributes
+}
+
+// readSelected adds the selected keys of the key value file as attributes with the given prefix.
+// The lines which can not be parsed are skipped.
+func (r *Reader) readSelected(name string, keys []string, prefix string, attributes map[string]string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	f, err := os.Open(filepath.Join(r.directory, name))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	values, _ := ParseKeyValues(f)
+	for _, key := range keys {
+		if value, found := values[key]; found {
+			attributes[prefix+key] = value
+		}
+	}
+}
+
+// ParseKeyValues parses data in the format of the labels and annotations files of a downward API
+// volume, where each line holds a key and its quoted value, such as tier="gold". The lines which
+// can not be parsed are skipped, and returned as errors along with the values of the others.
+func ParseKeyValues(data io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	var errs []error
+	scanner := bufio.NewScanner(data)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		key, quoted, found := strings.Cut(line, "=")
+		if !found {
+			errs = append(errs, fmt.Errorf("invalid line: %s", line))
+			continue
+		}
+
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unquote value of %s: %w", key, err))
+			continue
+		}
+		values[key] = value
+	}
+
+	return values, errors.Join(append(errs, scanner.Err())...)
+}
@@ -0,0 +1,105 @@
+package podinfo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	t.Run("Values parsed from the labels file", func(t *testing.T) {
+		f, err := os.Open("testdata/labels")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		values, err := ParseKeyValues(f)
+
+		assert.NoError(t, err, "Error on parsing the labels")
+		assert.Equal(t, map[string]string{
+			"app":            "mule-app",
+			"business-group": "finance",
+			"tier":           "gold",
+			"description":    "multi\nline \"quoted\"",
+		}, values)
+	})
+
+	t.Run("Invalid line", func(t *testing.T) {
+		values, err := ParseKeyValues(strings.NewReader("tier\napp=\"mule-app\"\n"))
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+		assert.Equal(t, map[string]string{"app": "mule-app"}, values, "The valid lines should be parsed anyway")
+	})
+
+	t.Run("Unquoted value", func(t *testing.T) {
+		values, err := ParseKeyValues(strings.NewReader("tier=gold\napp=\"mule-app\"\n"))
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+		assert.Equal(t, map[string]string{"app": "mule-app"}, values, "The valid lines should be parsed anyway")
+	})
+}
+
+func TestReader(t *testing.T) {
+	write := func(t *testing.T, path string, content string, modTime time.Time) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	t.Run("Selected attributes", func(t *testing.T) {
+		directory := t.TempDir()
+		modTime := time.Now()
+		write(t, filepath.Join(directory, "labels"), "tier=\"gold\"\napp=\"mule-app\"\n", modTime)
+		write(t, filepath.Join(directory, "annotations"), "owner=\"billing\"\n", modTime)
+		write(t, filepath.Join(directory, "namespace"), "apps\n", modTime)
+		write(t, filepath.Join(directory, "uid"), "5c8a6f0e-3b2d-4f7a-9e1c-8d6b4a2f0c13\n", modTime)
+
+		reader := New(Config{Enabled: true, Directory: directory, Labels: []string{"tier", "missing"}, Annotations: []string{"owner"}})
+
+		assert.Equal(t, map[string]string{
+			NamespaceAttribute:                  "apps",
+			PodUIDAttribute:                     "5c8a6f0e-3b2d-4f7a-9e1c-8d6b4a2f0c13",
+			LabelAttributePrefix + "tier":       "gold",
+			AnnotationAttributePrefix + "owner": "billing",
+		}, reader.Attributes())
+	})
+
+	t.Run("Files read again when they change", func(t *testing.T) {
+		directory := t.TempDir()
+		labels := filepath.Join(directory, "labels")
+		modTime := time.Now()
+		write(t, labels, "tier=\"gold\"\n", modTime)
+
+		reader := New(Config{Enabled: true, Directory: directory, Labels: []string{"tier"}, RefreshInterval: time.Minute})
+		clock := time.Now()
+		reader.now = func() time.Time { return clock }
+		assert.Equal(t, "gold", reader.Attributes()[LabelAttributePrefix+"tier"])
+
+		write(t, labels, "tier=\"silver\"\n", modTime.Add(time.Second))
+		assert.Equal(t, "gold", reader.Attributes()[LabelAttributePrefix+"tier"], "The files should not be checked before the refresh interval")
+
+		clock = clock.Add(time.Minute)
+		assert.Equal(t, "silver", reader.Attributes()[LabelAttributePrefix+"tier"])
+
+		assert.NoError(t, os.Remove(labels))
+		clock = clock.Add(time.Minute)
+		assert.Empty(t, reader.Attributes())
+	})
+
+	t.Run("Invalid lines skipped", func(t *testing.T) {
+		directory := t.TempDir()
+		write(t, filepath.Join(directory, "labels"), "broken\ntier=\"gold\"\n", time.Now())
+
+		reader := New(Config{Enabled: true, Directory: directory, Labels: []string{"tier"}})
+
+		assert.Equal(t, map[string]string{LabelAttributePrefix + "tier": "gold"}, reader.Attributes())
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		reader := New(Config{Enabled: true, Directory: filepath.Join(t.TempDir(), "missing"), Labels: []string{"tier"}})
+
+		assert.Empty(t, reader.Attributes())
+	})
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Default is the template rendering the data as it is serialized to JSON
+const Default = "{{ json . }}"
+
+// funcs are the functions available in the templates
+var funcs = template.FuncMap{
+	// json serializes a value to JSON, e.g. to quote a string
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	// timestamp formats a time in unix epoch milliseconds as RFC 3339 in UTC
+	"timestamp": func(ms int64) string {
+		return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+	},
+}
+
+// Renderer renders data into JSON with a Go text/template.
+type Renderer struct {
+	template *template.Template
+}
+
+// New creates a renderer of the given template. It fails if the template can not be parsed.
+func New(text string) (*Renderer, error) {
+	tmpl, err := template.New("sampler").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{template: tmpl}, nil
+}
+
+// Render executes the template with the data. The output is compacted to a single line, and must
+// be valid JSON.
+func (r *Renderer) Render(data any) ([]byte, error) {
+	var rendered bytes.Buffer
+	if err := r.template.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, rendered.Bytes()); err != nil {
+		return nil, fmt.Errorf("rendered invalid JSON: %w", err)
+	}
+	return compacted.Bytes(), nil
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	OrgID      string `json:"org_id"`
+	UsageBytes uint64 `json:"usage_bytes"`
+	EndMs      int64  `json:"end_ms,omitempty"`
+}
+
+type testEntry struct {
+	Format string      `json:"format"`
+	Events []testEvent `json:"events"`
+}
+
+func TestRender(t *testing.T) {
+	entry := testEntry{Format: "v1", Events: []testEvent{{OrgID: "org \"one\"", UsageBytes: 1024, EndMs: 1714564800000}, {OrgID: "two"}}}
+
+	t.Run("Default template", func(t *testing.T) {
+		renderer, err := New(Default)
+		assert.NoError(t, err)
+
+		rendered, err := renderer.Render(entry)
+		expected, _ := json.Marshal(entry)
+
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(rendered))
+	})
+
+	t.Run("Custom shape", func(t *testing.T) {
+		renderer, err := New(`{
+  "records": [{{ range $i, $e := .Events }}{{ if $i }},{{ end }}
+    {"org": {{ json $e.OrgID }}, "bytes": {{ $e.UsageBytes }}, "end": {{ json (timestamp $e.EndMs) }}}{{ end }}
+  ]
+}`)
+		assert.NoError(t, err)
+
+		rendered, err := renderer.Render(entry)
+
+		assert.NoError(t, err)
+		assert.Equal(t, `{"records":[{"org":"org \"one\"","bytes":1024,"end":"2024-05-01T12:00:00Z"},{"org":"two","bytes":0,"end":"1970-01-01T00:00:00Z"}]}`, string(rendered))
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		renderer, err := New(`{"org": {{ (index .Events 0).OrgID }}}`)
+		assert.NoError(t, err)
+
+		_, err = renderer.Render(entry)
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		renderer, err := New(`{{ .Unknown }}`)
+		assert.NoError(t, err)
+
+		_, err = renderer.Render(entry)
+
+		assert.Error(t, err, "Expected an error, but err was nil")
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(`{{ range .Events }}`)
+
+	assert.Error(t, err, "An unterminated template should fail")
+}
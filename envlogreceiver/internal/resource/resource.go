@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	procSelfCgroup    = "/proc/self/cgroup"
+	procSelfMountInfo = "/proc/self/mountinfo"
+)
+
+// Constants for the detected attributes, following the OTel semantic conventions
+const (
+	HostNameAttribute    = "host.name"
+	OSTypeAttribute      = "os.type"
+	ContainerIDAttribute = "container.id"
+	PodUIDAttribute      = "k8s.pod.uid"
+	ProcessPIDAttribute  = "process.pid"
+)
+
+// Config represents the configuration of the resource detection.
+type Config struct {
+	// Enabled enables the resource detection.
+	Enabled bool `mapstructure:"enabled"`
+}
+
+var (
+	// cgroupContainerID matches the container ID at the end of a cgroup path, such as
+	// /docker/<id>, /cri-containerd-<id>.scope or /crio-<id>.scope
+	cgroupContainerID = regexp.MustCompile(`[/-]([0-9a-f]{64})(?:\.scope)?$`)
+	// cgroupPodUID matches the pod UID in a cgroup path, where the systemd driver replaces the
+	// dashes of the UID by underscores
+	cgroupPodUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	// mountInfoContainerID matches the container ID in the source of the files mounted from the
+	// container directory, such as /var/lib/docker/containers/<id>/resolv.conf
+	mountInfoContainerID = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+	// mountInfoPodUID matches the pod UID in the source of the files mounted by the kubelet
+	mountInfoPodUID = regexp.MustCompile(`/pods/([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})/`)
+)
+
+// Detect returns the attributes of the host, container and process the receiver runs in. The
+// attributes which can not be detected are omitted.
+//
+// The container ID and pod UID are read from /proc/self/cgroup, or from /proc/self/mountinfo when
+// the cgroup paths do not hold them, which is the case with cgroup v2 namespaces.
+func Detect() map[string]string {
+	attributes := map[string]string{
+		OSTypeAttribute:     runtime.GOOS,
+		ProcessPIDAttribute: strconv.Itoa(os.Getpid()),
+	}
+
+	if hostName, err := os.Hostname(); err == nil {
+		attributes[HostNameAttribute] = hostName
+	}
+
+	var containerID, podUID string
+	if f, err := os.Open(procSelfCgroup); err == nil {
+		containerID, podUID = ParseCgroup(f)
+		f.Close()
+	}
+	if containerID == "" || podUID == "" {
+		if f, err := os.Open(procSelfMountInfo); err == nil {
+			mountContainerID, mountPodUID := ParseMountInfo(f)
+			f.Close()
+			if containerID == "" {
+				containerID = mountContainerID
+			}
+			if podUID == "" {
+				podUID = mountPodUID
+			}
+		}
+	}
+
+	if containerID != "" {
+		attributes[ContainerIDAttribute] = containerID
+	}
+	if podUID != "" {
+		attributes[PodUIDAttribute] = podUID
+	}
+
+	return attributes
+}
+
+// ParseCgroup parses the container ID and pod UID from data in the format of /proc/self/cgroup,
+// where each line holds the hierarchy ID, the controllers and the cgroup path. Each is empty if
+// it is not found.
+func ParseCgroup(data io.Reader) (string, string) {
+	var containerID, podUID string
+	scanner := bufio.NewScanner(data)
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		path := fields[2]
+		if match := cgroupContainerID.FindStringSubmatch(path); match != nil && containerID == "" {
+			containerID = match[1]
+		}
+		if match := cgroupPodUID.FindStringSubmatch(path); match != nil && podUID == "" {
+			podUID = strings.ReplaceAll(match[1], "_", "-")
+		}
+	}
+
+	return containerID, podUID
+}
+
+// ParseMountInfo parses the container ID and pod UID from data in the format of
+// /proc/self/mountinfo, where the fourth field of each line is the source of the mount. Each is
+// empty if it is not found. With containerd, the mounts only refer to the pod sandbox, which is
+// another container, so the container ID is not found.
+func ParseMountInfo(data io.Reader) (string, string) {
+	var containerID, podUID string
+	scanner := bufio.NewScanner(data)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		root := fields[3]
+		if match := mountInfoContainerID.FindStringSubmatch(root); match != nil && containerID == "" {
+			containerID = match[1]
+		}
+		if match := mountInfoPodUID.FindStringSubmatch(root); match != nil && podUID == "" {
+			podUID = match[1]
+		}
+	}
+
+	return containerID, podUID
+}
@@ -0,0 +1,87 @@
+package resource
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testContainerID = "2f4d7c9e1a3b5d7f9e1c3a5b7d9f1e3c5a7b9d1f3e5c7a9b1d3f5e7c9a1b3d5f"
+	testPodUID      = "5c8a6f0e-3b2d-4f7a-9e1c-8d6b4a2f0c13"
+)
+
+func TestParseCgroup(t *testing.T) {
+	t.Run("Cgroup v1 paths", func(t *testing.T) {
+		f, err := os.Open("testdata/cgroup_v1.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		containerID, podUID := ParseCgroup(f)
+
+		assert.Equal(t, testContainerID, containerID)
+		assert.Equal(t, testPodUID, podUID)
+	})
+
+	t.Run("Systemd cgroup driver paths", func(t *testing.T) {
+		f, err := os.Open("testdata/cgroup_systemd.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		containerID, podUID := ParseCgroup(f)
+
+		assert.Equal(t, testContainerID, containerID)
+		assert.Equal(t, testPodUID, podUID)
+	})
+
+	t.Run("Cgroup namespace", func(t *testing.T) {
+		containerID, podUID := ParseCgroup(strings.NewReader("0::/\n"))
+
+		assert.Empty(t, containerID)
+		assert.Empty(t, podUID)
+	})
+}
+
+func TestParseMountInfo(t *testing.T) {
+	t.Run("Docker container directory", func(t *testing.T) {
+		f, err := os.Open("testdata/mountinfo.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		containerID, podUID := ParseMountInfo(f)
+
+		assert.Equal(t, testContainerID, containerID, "The sandbox ID should not be taken as the container ID")
+		assert.Equal(t, testPodUID, podUID)
+	})
+
+	t.Run("Containerd sandbox directory", func(t *testing.T) {
+		f, err := os.Open("testdata/mountinfo_containerd.data")
+		assert.NoError(t, err, "Error on opening the test file")
+		defer f.Close()
+
+		containerID, podUID := ParseMountInfo(f)
+
+		assert.Empty(t, containerID, "The sandbox ID should not be taken as the container ID")
+		assert.Equal(t, testPodUID, podUID)
+	})
+
+	t.Run("No container mounts", func(t *testing.T) {
+		containerID, podUID := ParseMountInfo(strings.NewReader("1402 1384 0:152 / / rw,relatime - overlay overlay rw\n"))
+
+		assert.Empty(t, containerID)
+		assert.Empty(t, podUID)
+	})
+}
+
+func TestDetect(t *testing.T) {
+	attributes := Detect()
+
+	hostName, _ := os.Hostname()
+	assert.Equal(t, hostName, attributes[HostNameAttribute])
+	assert.Equal(t, runtime.GOOS, attributes[OSTypeAttribute])
+	assert.Equal(t, strconv.Itoa(os.Getpid()), attributes[ProcessPIDAttribute])
+}
@@ -0,0 +1,114 @@
+package rollup
+
+import (
+	"maps"
+	"time"
+)
+
+// Constants for the rollup periods
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// periods are the periods the usage is rolled up into
+var periods = []string{PeriodDay, PeriodMonth}
+
+// Totals are the running totals of a period.
+type Totals struct {
+	// Period is the kind of period, day or month
+	Period string `json:"period"`
+	// StartMs is the start of the period in unix epoch milliseconds
+	StartMs int64 `json:"start_ms"`
+	// EndMs is the end of the period in unix epoch milliseconds
+	EndMs int64 `json:"end_ms"`
+	// Labels identify whom the usage is accounted to
+	Labels map[string]string `json:"labels,omitempty"`
+	// UsageBytes is the total usage of the intervals
+	UsageBytes uint64 `json:"usage_bytes"`
+	// Intervals is the number of intervals rolled up
+	Intervals int `json:"intervals"`
+	// Gaps is the number of gaps detected
+	Gaps int `json:"gaps"`
+	// GapDurationMs is the total duration of the gaps in milliseconds
+	GapDurationMs int64 `json:"gap_duration_ms"`
+}
+
+// State is the persisted state of a rollup, holding the totals of the open periods.
+type State struct {
+	Open []Totals `json:"open"`
+}
+
+// Interval is an interval rolled up into the totals of the period containing its end.
+type Interval struct {
+	// End is the end of the interval
+	End time.Time
+	// UsageBytes is the usage during the interval
+	UsageBytes uint64
+	// Gap is the duration of the gap detected before the interval, zero if none
+	Gap time.Duration
+	// Labels identify whom the usage is accounted to
+	Labels map[string]string
+}
+
+// Add rolls up the interval into the totals of the day and the month containing its end, in the
+// given location. Periods are closed once an interval of a later period is added, or when the labels
+// change, since the totals are kept per labels. It returns the new state, leaving the given one
+// unmodified, and the totals of the periods closed by the interval.
+func Add(state State, interval Interval, location *time.Location) (State, []Totals) {
+	// An interval ending on a period boundary belongs to the period before
+	at := interval.End.Add(-time.Millisecond).In(location)
+
+	var next State
+	var closed []Totals
+
+	for _, period := range periods {
+		start, end := bounds(period, at)
+
+		totals, found := state.Current(period)
+		if found && (totals.StartMs != start.UnixMilli() || !maps.Equal(totals.Labels, interval.Labels)) {
+			closed = append(closed, totals)
+			found = false
+		}
+		if !found {
+			totals = Totals{
+				Period:  period,
+				StartMs: start.UnixMilli(),
+				EndMs:   end.UnixMilli(),
+				Labels:  maps.Clone(interval.Labels),
+			}
+		}
+
+		totals.UsageBytes += interval.UsageBytes
+		totals.Intervals++
+		if interval.Gap > 0 {
+			totals.Gaps++
+			totals.GapDurationMs += interval.Gap.Milliseconds()
+		}
+
+		next.Open = append(next.Open, totals)
+	}
+
+	return next, closed
+}
+
+// Current returns the totals of the open period of the given kind, if any.
+func (s State) Current(period string) (Totals, bool) {
+	for _, totals := range s.Open {
+		if totals.Period == period {
+			return totals, true
+		}
+	}
+	return Totals{}, false
+}
+
+// bounds returns the start and the end of the period containing t, in the location of t.
+func bounds(period string, t time.Time) (time.Time, time.Time) {
+	if period == PeriodMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
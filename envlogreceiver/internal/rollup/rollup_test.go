@@ -0,0 +1,80 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdd(t *testing.T) {
+	labels := map[string]string{"worker_id": "worker-0"}
+	at := func(value string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return parsed
+	}
+
+	t.Run("Intervals rolled up into the open periods", func(t *testing.T) {
+		state, closed := Add(State{}, Interval{End: at("2024-05-31T10:00:00Z"), UsageBytes: 100, Labels: labels}, time.UTC)
+		state, closed2 := Add(state, Interval{End: at("2024-05-31T10:15:00Z"), UsageBytes: 50, Gap: time.Hour, Labels: labels}, time.UTC)
+
+		assert.Empty(t, closed)
+		assert.Empty(t, closed2)
+		assert.Len(t, state.Open, 2)
+		day, _ := state.Current(PeriodDay)
+		assert.Equal(t, at("2024-05-31T00:00:00Z").UnixMilli(), day.StartMs)
+		assert.Equal(t, at("2024-06-01T00:00:00Z").UnixMilli(), day.EndMs)
+		assert.Equal(t, uint64(150), day.UsageBytes)
+		assert.Equal(t, 2, day.Intervals)
+		assert.Equal(t, 1, day.Gaps)
+		assert.Equal(t, time.Hour.Milliseconds(), day.GapDurationMs)
+		month, _ := state.Current(PeriodMonth)
+		assert.Equal(t, at("2024-05-01T00:00:00Z").UnixMilli(), month.StartMs)
+		assert.Equal(t, uint64(150), month.UsageBytes)
+	})
+
+	t.Run("Interval ending on the boundary belongs to the period before", func(t *testing.T) {
+		state, _ := Add(State{}, Interval{End: at("2024-05-31T23:45:00Z"), UsageBytes: 100, Labels: labels}, time.UTC)
+		state, closed := Add(state, Interval{End: at("2024-06-01T00:00:00Z"), UsageBytes: 50, Labels: labels}, time.UTC)
+
+		assert.Empty(t, closed)
+		day, _ := state.Current(PeriodDay)
+		assert.Equal(t, uint64(150), day.UsageBytes)
+	})
+
+	t.Run("Periods closed by a later interval", func(t *testing.T) {
+		state, _ := Add(State{}, Interval{End: at("2024-05-31T23:45:00Z"), UsageBytes: 100, Labels: labels}, time.UTC)
+		state, closed := Add(state, Interval{End: at("2024-06-01T00:15:00Z"), UsageBytes: 50, Labels: labels}, time.UTC)
+
+		assert.Len(t, closed, 2)
+		assert.Equal(t, PeriodDay, closed[0].Period)
+		assert.Equal(t, uint64(100), closed[0].UsageBytes)
+		assert.Equal(t, labels, closed[0].Labels)
+		assert.Equal(t, PeriodMonth, closed[1].Period)
+		assert.Equal(t, at("2024-06-01T00:00:00Z").UnixMilli(), closed[1].EndMs)
+		day, _ := state.Current(PeriodDay)
+		assert.Equal(t, uint64(50), day.UsageBytes)
+		assert.Equal(t, 1, day.Intervals)
+	})
+
+	t.Run("Periods closed when the labels change", func(t *testing.T) {
+		state, _ := Add(State{}, Interval{End: at("2024-05-31T10:00:00Z"), UsageBytes: 100, Labels: labels}, time.UTC)
+		state, closed := Add(state, Interval{End: at("2024-05-31T10:15:00Z"), UsageBytes: 50, Labels: map[string]string{"worker_id": "worker-1"}}, time.UTC)
+
+		assert.Len(t, closed, 2)
+		assert.Equal(t, "worker-0", closed[0].Labels["worker_id"])
+		day, _ := state.Current(PeriodDay)
+		assert.Equal(t, "worker-1", day.Labels["worker_id"])
+		assert.Equal(t, uint64(50), day.UsageBytes)
+	})
+
+	t.Run("Periods in a location", func(t *testing.T) {
+		location := time.FixedZone("UTC-3", -3*60*60)
+		state, _ := Add(State{}, Interval{End: at("2024-06-01T02:00:00Z"), UsageBytes: 100, Labels: labels}, location)
+
+		day, _ := state.Current(PeriodDay)
+		assert.Equal(t, at("2024-05-31T03:00:00Z").UnixMilli(), day.StartMs)
+		month, _ := state.Current(PeriodMonth)
+		assert.Equal(t, at("2024-05-01T03:00:00Z").UnixMilli(), month.StartMs)
+	})
+}
@@ -0,0 +1,76 @@
+package window
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregator accumulates the deltas observed between consecutive readings of a counter
+// within a window and summarizes them. The zero value is an empty window.
+type Aggregator struct {
+	deltas   []uint64
+	peakRate float64
+}
+
+// Summary is the summary of the deltas observed within a window.
+type Summary struct {
+	// Samples is the number of deltas observed in the window.
+	Samples int
+	// Total is the sum of the deltas.
+	Total uint64
+	// Min is the lowest delta.
+	Min uint64
+	// Max is the highest delta.
+	Max uint64
+	// Avg is the average delta.
+	Avg float64
+	// P95 is the 95th percentile of the deltas, using the nearest-rank method.
+	P95 uint64
+	// PeakRatePerSecond is the highest per-second rate among the observed deltas.
+	PeakRatePerSecond float64
+}
+
+// Add adds the increase of the counter since the previous reading, which is elapsed ago, to the window.
+func (a *Aggregator) Add(delta uint64, elapsed time.Duration) {
+	a.deltas = append(a.deltas, delta)
+
+	if elapsed > 0 {
+		a.peakRate = math.Max(a.peakRate, float64(delta)/elapsed.Seconds())
+	}
+}
+
+// Summary returns the summary of the deltas added since the last reset.
+// An empty window returns a zero Summary.
+func (a *Aggregator) Summary() Summary {
+	if len(a.deltas) == 0 {
+		return Summary{}
+	}
+
+	sorted := make([]uint64, len(a.deltas))
+	copy(sorted, a.deltas)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total uint64
+	for _, delta := range sorted {
+		total += delta
+	}
+
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+
+	return Summary{
+		Samples:           len(sorted),
+		Total:             total,
+		Min:               sorted[0],
+		Max:               sorted[len(sorted)-1],
+		Avg:               float64(total) / float64(len(sorted)),
+		P95:               sorted[rank],
+		PeakRatePerSecond: a.peakRate,
+	}
+}
+
+// Reset discards the deltas added so far and starts a new window.
+func (a *Aggregator) Reset() {
+	a.deltas = a.deltas[:0]
+	a.peakRate = 0
+}
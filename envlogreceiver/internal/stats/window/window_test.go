@@ -0,0 +1,48 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregator(t *testing.T) {
+	t.Run("empty window returns a zero summary", func(t *testing.T) {
+		aggregator := &Aggregator{}
+
+		assert.Equal(t, Summary{}, aggregator.Summary())
+	})
+
+	t.Run("summarizes the deltas of the window", func(t *testing.T) {
+		aggregator := &Aggregator{}
+
+		for i := uint64(1); i <= 20; i++ {
+			aggregator.Add(i*10, time.Second)
+		}
+		aggregator.Add(600, 2*time.Second)
+
+		summary := aggregator.Summary()
+
+		assert.Equal(t, 21, summary.Samples)
+		assert.Equal(t, uint64(2700), summary.Total)
+		assert.Equal(t, uint64(10), summary.Min)
+		assert.Equal(t, uint64(600), summary.Max)
+		assert.Equal(t, float64(2700)/21, summary.Avg)
+		assert.Equal(t, uint64(200), summary.P95)
+		assert.Equal(t, float64(300), summary.PeakRatePerSecond)
+	})
+
+	t.Run("reset starts a new window", func(t *testing.T) {
+		aggregator := &Aggregator{}
+		aggregator.Add(100, time.Second)
+
+		aggregator.Reset()
+		aggregator.Add(5, time.Second)
+
+		summary := aggregator.Summary()
+		assert.Equal(t, 1, summary.Samples)
+		assert.Equal(t, uint64(5), summary.P95)
+		assert.Equal(t, float64(5), summary.PeakRatePerSecond)
+	})
+}